@@ -0,0 +1,258 @@
+/*
+	Venjector: Copyright (C) 2023 tizu69
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+// Bump this when the layout of a bundle changes in a way older Venjectors can't import
+const bundleVersion = 1
+
+// bundleManifest is manifest.json in a bundle.
+type bundleManifest struct {
+	Version int       `json:"version"`
+	Created time.Time `json:"created"`
+	Commit  string    `json:"commit"`
+	Remote  []string  `json:"remote"`
+}
+
+// exportBundle is the 'venjector export' command. It packs everything needed to rebuild on another machine:
+// the Vencord checkout as a git bundle, overrides, remote plugins (with their sources) and config.
+func exportBundle() error {
+	repoLocation := filepath.Join(getBuildPath(), repoDir)
+	if _, err := os.Stat(repoLocation); err != nil {
+		return wrapError(categoryPrerequisite, "Nothing to export, reload plugins first", err)
+	}
+
+	remote, err := readRemoteList()
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.MkdirTemp("", "venjector-export")
+	if err != nil {
+		return wrapError(categoryFilesystem, "Failed to create temporary directory", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	log.Info("Bundling Vencord checkout")
+	gitBundle := filepath.Join(tmp, "vencord.bundle")
+	command := newCommand("git", "bundle", "create", gitBundle, "--all")
+	command.Dir = repoLocation
+	if err := runLogged("git-bundle", command, nil); err != nil {
+		return wrapError(categoryBuild, "Failed to bundle Vencord checkout", err)
+	}
+
+	manifest, err := json.MarshalIndent(bundleManifest{
+		Version: bundleVersion,
+		Created: time.Now(),
+		Commit:  builtCommit(),
+		Remote:  remote,
+	}, "", "\t")
+	if err != nil {
+		return wrapError(categoryUnknown, "Failed to marshal manifest", err)
+	}
+
+	out, err := os.Create(cli.Export.Output)
+	if err != nil {
+		return wrapError(categoryFilesystem, "Failed to create "+cli.Export.Output, err)
+	}
+	defer out.Close()
+
+	gz := gzip.NewWriter(out)
+	tw := tar.NewWriter(gz)
+
+	err = writeTarFile(tw, "manifest.json", manifest)
+	if err == nil {
+		err = addToTar(tw, gitBundle, "vencord.bundle")
+	}
+	for _, f := range bundledFiles() {
+		if _, statErr := os.Stat(f.path); err == nil && statErr == nil {
+			err = addToTar(tw, f.path, f.name)
+		}
+	}
+	for _, plugin := range remote {
+		cached := remoteCachePath(plugin)
+		rel, _ := filepath.Rel(getCachePath(), cached)
+		if _, statErr := os.Stat(cached); err == nil && statErr == nil {
+			err = addToTar(tw, cached, filepath.ToSlash(filepath.Join("cache", rel)))
+		} else if statErr != nil {
+			addWarning("Remote plugin " + plugin + " was never downloaded, it won't be in the bundle")
+		}
+	}
+
+	if err == nil {
+		err = tw.Close()
+	}
+	if err == nil {
+		err = gz.Close()
+	}
+	if err != nil {
+		return wrapError(categoryFilesystem, "Failed to write "+cli.Export.Output, err)
+	}
+
+	log.Info("Exported", "to", cli.Export.Output)
+	fmt.Println(cli.Export.Output)
+	return nil
+}
+
+// bundledFiles are the settings a bundle carries, and where they go. Everything but config.json is the profile's.
+func bundledFiles() []struct{ name, path string } {
+	return []struct{ name, path string }{
+		{"overrides", filepath.Join(getProfilePath(), "overrides")},
+		{"remote.json", filepath.Join(getProfilePath(), "remote.json")},
+		{"profile.json", filepath.Join(getProfilePath(), "profile.json")},
+		{"config.json", filepath.Join(getConfigPath(), "config.json")},
+	}
+}
+
+func writeTarFile(tw *tar.Writer, name string, data []byte) error {
+	err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), ModTime: time.Now()})
+	if err != nil {
+		return err
+	}
+	_, err = tw.Write(data)
+	return err
+}
+
+// addToTar adds a file or a directory, recursively, to tw as name.
+func addToTar(tw *tar.Writer, path, name string) error {
+	return filepath.WalkDir(path, func(file string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(path, file)
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(filepath.Join(name, rel))
+		if d.IsDir() {
+			header.Name += "/"
+		} else if !d.Type().IsRegular() {
+			log.Warn("Skipping, not a regular file", "file", file)
+			return nil
+		}
+
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		_, err = io.Copy(tw, f)
+		return err
+	})
+}
+
+// importBundle restores a bundle made by exportBundle into the data directory. It sets up an offline rebuild
+// from the bundled checkout, main takes it from there.
+func importBundle() error {
+	dir := filepath.Join(getCachePath(), "import")
+	os.RemoveAll(dir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return wrapError(categoryFilesystem, "Failed to create "+dir, err)
+	}
+
+	log.Info("Extracting bundle", "bundle", cli.Import.Bundle, "to", dir)
+	if err := extractArchive(cli.Import.Bundle, dir, false); err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "manifest.json"))
+	if err != nil {
+		return wrapError(categoryFilesystem, "Not a Venjector bundle", err)
+	}
+	manifest := bundleManifest{}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return wrapError(categoryFilesystem, "Not a Venjector bundle", err)
+	}
+	if manifest.Version > bundleVersion {
+		return wrapError(categoryPrerequisite, "Bundle is from a newer Venjector, update first",
+			fmt.Errorf("bundle version %d", manifest.Version))
+	}
+	log.Info("Importing bundle", "commit", manifest.Commit, "created", manifest.Created)
+
+	if err := os.MkdirAll(getProfilePath(), 0755); err != nil {
+		return wrapError(categoryFilesystem, "Failed to create "+getProfilePath(), err)
+	}
+
+	for _, f := range bundledFiles() {
+		name, from, to := f.name, filepath.Join(dir, f.name), f.path
+		if _, err := os.Stat(from); err != nil {
+			continue
+		}
+
+		// Don't just throw away what was there
+		if _, err := os.Stat(to); err == nil {
+			backup := to + ".before-import"
+			log.Info("Backing up", "from", to, "to", backup)
+			os.RemoveAll(backup)
+			if err := os.Rename(to, backup); err != nil {
+				return wrapError(categoryFilesystem, "Failed to back up "+to, err)
+			}
+		}
+
+		if err := os.Rename(from, to); err != nil {
+			return wrapError(categoryFilesystem, "Failed to restore "+name, err)
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Join(getCachePath(), "remote"), 0755); err != nil {
+		return wrapError(categoryFilesystem, "Failed to create plugin cache", err)
+	}
+	entries, _ := os.ReadDir(filepath.Join(dir, "cache", "remote"))
+	for _, entry := range entries {
+		to := filepath.Join(getCachePath(), "remote", entry.Name())
+		os.RemoveAll(to)
+		if err := os.Rename(filepath.Join(dir, "cache", "remote", entry.Name()), to); err != nil {
+			return wrapError(categoryFilesystem, "Failed to restore plugin cache", err)
+		}
+	}
+
+	cli.Offline = true
+	cli.RepoBundle = filepath.Join(dir, "vencord.bundle")
+	return nil
+}
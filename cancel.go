@@ -0,0 +1,97 @@
+/*
+	Venjector: Copyright (C) 2023 tizu69
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+// How long the main flow gets to notice a cancel before we pull the plug ourselves, e.g. when it's stuck in a dialog
+const cancelGrace = 10 * time.Second
+
+// runCtx is canceled when the user cancels the progress dialog or sends us SIGINT/SIGTERM.
+// Every subprocess and HTTP request is bound to it.
+var runCtx, cancelRun = context.WithCancel(context.Background())
+
+var rollbackOnce sync.Once
+
+// watchSignals cancels the run on the first Ctrl-C or SIGTERM, and exits right away on the second.
+func watchSignals() {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		sig := <-signals
+		log.Warn("Canceling, press Ctrl-C again to force", "signal", sig)
+		cancelRun()
+
+		select {
+		case <-signals:
+		case <-time.After(cancelGrace):
+		}
+
+		rollback()
+		exit(exitCanceled)
+	}()
+}
+
+// checkCanceled aborts the run if it was canceled.
+func checkCanceled() {
+	if err := runCtx.Err(); err != nil {
+		fatal(wrapError(categoryCanceled, "Canceled", err))
+	}
+}
+
+// rollback throws away a half-done rebuild. The last good build in cord is only replaced once a rebuild
+// finished (see commitRepo), so this is all it takes to get back to a consistent state.
+func rollback() {
+	rollbackOnce.Do(func() {
+		if heldLock == "" {
+			return // that rebuild isn't ours
+		}
+
+		staging := filepath.Join(getBuildPath(), stagingDir)
+		if _, err := os.Stat(staging); err == nil {
+			log.Info("Discarding unfinished rebuild", "location", staging)
+			os.RemoveAll(staging)
+		}
+	})
+}
+
+// newCommand is exec.Command, but bound to runCtx. Canceling kills the whole process group, as pnpm likes
+// to spawn children of its own.
+func newCommand(name string, args ...string) *exec.Cmd {
+	return newCommandContext(runCtx, name, args...)
+}
+
+// newCommandContext is newCommand with a context of your own, which should be derived from runCtx.
+func newCommandContext(ctx context.Context, name string, args ...string) *exec.Cmd {
+	command := exec.CommandContext(ctx, name, args...)
+	setProcessGroup(command)
+	command.WaitDelay = 5 * time.Second
+	return command
+}
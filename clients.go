@@ -0,0 +1,303 @@
+/*
+	Venjector: Copyright (C) 2023 tizu69
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/charmbracelet/log"
+	"github.com/ncruces/zenity"
+)
+
+const (
+	kindDiscord = "discord"
+	kindVesktop = "vesktop"
+)
+
+// client is an installed Discord client we can inject.
+type client struct {
+	Name string `json:"name"`
+	Kind string `json:"kind"`
+	// For Discord, the directory containing the resources directory. For Vesktop, its config directory.
+	Path      string `json:"path"`
+	ReadOnly  bool   `json:"readOnly,omitempty"`  // Snaps can't be patched
+	Sandboxed bool   `json:"sandboxed,omitempty"` // Flatpak Vesktop, which can't read our build where it is
+}
+
+// discordCandidate is a place a Discord client might be installed.
+type discordCandidate struct {
+	name     string
+	path     string
+	readOnly bool
+}
+
+func discordCandidates() []discordCandidate {
+	home, _ := os.UserHomeDir()
+	candidates := []discordCandidate{}
+
+	switch runtime.GOOS {
+	case "linux":
+		for _, branch := range []struct{ name, dir, flatpak, flatpakDir string }{
+			{"Discord", "discord", "com.discordapp.Discord", "discord"},
+			{"Discord PTB", "discord-ptb", "com.discordapp.DiscordPTB", "discord-ptb"},
+			{"Discord Canary", "discord-canary", "com.discordapp.DiscordCanary", "discord-canary"},
+		} {
+			for _, base := range []string{"/usr/share", "/usr/lib", "/usr/lib64", "/opt", filepath.Join(home, ".local/share")} {
+				candidates = append(candidates, discordCandidate{branch.name, filepath.Join(base, branch.dir), false})
+			}
+			// Some packages use the names of the official tarballs
+			candidates = append(candidates, discordCandidate{
+				branch.name, filepath.Join("/opt", strings.ReplaceAll(branch.name, " ", "")), false})
+
+			for _, base := range []string{"/var/lib/flatpak/app", filepath.Join(home, ".local/share/flatpak/app")} {
+				candidates = append(candidates, discordCandidate{branch.name + " (Flatpak)",
+					filepath.Join(base, branch.flatpak, "current/active/files", branch.flatpakDir), false})
+			}
+
+			candidates = append(candidates, discordCandidate{branch.name + " (Snap)",
+				filepath.Join("/snap", branch.dir, "current/usr/share", branch.dir), true})
+		}
+	case "darwin":
+		for _, name := range []string{"Discord", "Discord PTB", "Discord Canary"} {
+			for _, base := range []string{"/Applications", filepath.Join(home, "Applications")} {
+				candidates = append(candidates, discordCandidate{name, filepath.Join(base, name+".app", "Contents"), false})
+			}
+		}
+	case "windows":
+		for _, name := range []string{"Discord", "DiscordPTB", "DiscordCanary"} {
+			// Discord keeps one app-x.y.z directory per version, the newest one is in use
+			versions, _ := filepath.Glob(filepath.Join(os.Getenv("LOCALAPPDATA"), name, "app-*"))
+			sort.Strings(versions)
+			if len(versions) != 0 {
+				candidates = append(candidates, discordCandidate{name, versions[len(versions)-1], false})
+			}
+		}
+	}
+
+	return candidates
+}
+
+// resourcesPath is where a Discord client keeps app.asar.
+func resourcesPath(c client) string {
+	if runtime.GOOS == "darwin" {
+		return filepath.Join(c.Path, "Resources")
+	}
+	return filepath.Join(c.Path, "resources")
+}
+
+// findClients looks for installed clients in all the usual places.
+func findClients() []client {
+	clients := []client{}
+	seen := map[string]bool{}
+
+	for _, candidate := range discordCandidates() {
+		c := client{Name: candidate.name, Kind: kindDiscord, Path: candidate.path, ReadOnly: candidate.readOnly}
+
+		// Distros love symlinking these around, only list each install once
+		real, err := filepath.EvalSymlinks(resourcesPath(c))
+		if err != nil || seen[real] {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(real, "app.asar")); err != nil {
+			if _, err := os.Stat(filepath.Join(real, "_app.asar")); err != nil {
+				continue
+			}
+		}
+
+		seen[real] = true
+		clients = append(clients, c)
+	}
+
+	clients = append(clients, findVesktops()...)
+
+	log.Info("Found clients", "count", len(clients))
+	return clients
+}
+
+func injectClient(c client) error {
+	if c.ReadOnly {
+		return wrapError(categoryPermission, c.Name+" can't be injected", os.ErrPermission)
+	}
+
+	if _, err := os.Stat(patcherPath()); err != nil {
+		return wrapError(categoryPrerequisite, "Nothing to inject, reload plugins first", err)
+	}
+
+	log.Info("Injecting", "client", c.Name, "path", c.Path)
+	switch c.Kind {
+	case kindVesktop:
+		return injectVesktop(c)
+	}
+
+	return patchAsar(resourcesPath(c), patcherPath())
+}
+
+func uninjectClient(c client) error {
+	if c.ReadOnly {
+		return wrapError(categoryPermission, c.Name+" can't be uninjected", os.ErrPermission)
+	}
+
+	log.Info("Uninjecting", "client", c.Name, "path", c.Path)
+	switch c.Kind {
+	case kindVesktop:
+		return uninjectVesktop(c)
+	}
+
+	return unpatchAsar(resourcesPath(c))
+}
+
+// clientAt makes a client out of a path given by hand: a Vesktop config directory, a Discord install or its
+// resources directory.
+func clientAt(path string) (client, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return client{}, wrapError(categoryFilesystem, "Invalid client location", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(path, "settings.json")); err == nil {
+		return vesktopAt(path), nil
+	}
+
+	c := client{Name: filepath.Base(path), Kind: kindDiscord, Path: path}
+	if filepath.Base(path) == filepath.Base(resourcesPath(c)) {
+		c = client{Name: filepath.Base(filepath.Dir(path)), Kind: kindDiscord, Path: filepath.Dir(path)}
+	}
+
+	for _, name := range []string{"app.asar", originalAsar} {
+		if _, err := os.Stat(filepath.Join(resourcesPath(c), name)); err == nil {
+			return c, nil
+		}
+	}
+	return client{}, wrapError(categoryPrerequisite, "Not a Discord install or Vesktop config directory: "+path,
+		os.ErrNotExist)
+}
+
+// cliClients are the clients 'venjector inject' and 'venjector uninject' act on: the one at location, or
+// every one we can find.
+func cliClients(location string) ([]client, error) {
+	if location != "" {
+		c, err := clientAt(location)
+		return []client{c}, err
+	}
+
+	// A profile made for one client sticks to it
+	if p, err := loadProfile(currentProfile()); err != nil {
+		return nil, err
+	} else if p.Client != "" {
+		return cliClients(p.Client)
+	}
+
+	clients := []client{}
+	for _, c := range findClients() {
+		if !c.ReadOnly {
+			clients = append(clients, c)
+		}
+	}
+	if len(clients) == 0 {
+		return nil, wrapError(categoryPrerequisite, "No Discord clients found, pass a location", os.ErrNotExist)
+	}
+	return clients, nil
+}
+
+// injectCommand is 'venjector inject', which needs no GUI.
+func injectCommand() error {
+	clients, err := cliClients(cli.Inject.Location)
+	if err != nil {
+		return err
+	}
+	for _, c := range clients {
+		if err := injectClient(c); err != nil {
+			return err
+		}
+		fmt.Println("Injected", c.Name, "at", c.Path)
+	}
+	return nil
+}
+
+// uninjectCommand is 'venjector uninject'.
+func uninjectCommand() error {
+	clients, err := cliClients(cli.Uninject.Location)
+	if err != nil {
+		return err
+	}
+	for _, c := range clients {
+		if err := uninjectClient(c); err != nil {
+			return err
+		}
+		fmt.Println("Uninjected", c.Name, "at", c.Path)
+	}
+	return nil
+}
+
+// manageClients is the 'Install or uninstall Venjector' menu: every client we found, with buttons to inject
+// or uninject it, and a way to pick one by hand.
+func manageClients() {
+	const pickByHand = "Pick a location by hand..."
+
+	for {
+		items := []string{}
+		byItem := map[string]client{}
+		for _, c := range findClients() {
+			status := getStatus(c).State.String()
+			if c.ReadOnly {
+				status += ", read-only"
+			}
+
+			item := c.Name + " (" + status + ") - " + c.Path
+			items = append(items, item)
+			byItem[item] = c
+		}
+		items = append(items, pickByHand)
+
+		sel, err := zenity.List("Clients (Venjector)\nRestart (not just hide!) a client after changing it.", items,
+			zenity.Title("Venjector"), zenity.DisallowEmpty(), zenity.Width(768), zenity.Height(384),
+			zenity.OKLabel("Install"), zenity.ExtraButton("Uninstall"), zenity.CancelLabel("Done"))
+
+		var run func(client) error
+		switch err {
+		case nil:
+			run = injectClient
+		case zenity.ErrExtraButton:
+			run = uninjectClient
+		default:
+			return
+		}
+
+		c, ok := byItem[sel]
+		if !ok {
+			path, err := zenity.SelectFile(zenity.Title("Discord install or Vesktop config directory"), zenity.Directory())
+			if err != nil {
+				continue
+			}
+			if c, err = clientAt(path); err != nil {
+				zenity.Error(err.Error(), zenity.Title("Venjector"))
+				continue
+			}
+		}
+
+		newProgress(1)
+		setVal(1, "Updating "+c.Name, func() error {
+			return run(c)
+		})
+	}
+}
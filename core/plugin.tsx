@@ -4,19 +4,39 @@
  * SPDX-License-Identifier: GPL-3.0-or-later
  */
 
-import { definePluginSettings } from "@api/Settings";
+import { definePluginSettings, Settings } from "@api/Settings";
+import { Margins } from "@utils/margins";
+import { classes } from "@utils/misc";
+import { relaunch } from "@utils/native";
 import definePlugin, { OptionType, PluginNative } from "@utils/types";
-import { Toasts } from "@webpack/common";
+import { Alerts, Button, Forms, React, Toasts } from "@webpack/common";
 
 // KEEP THIS! Generates native code.
 const Native = VencordNative.pluginHelpers.Core as PluginNative<typeof import("./native")>;
 
+// withProgress runs action, a reload, telling onProgress how far along it is every now and then
+export async function withProgress<T>(onProgress: (text: string | null) => void, action: () => Promise<T>): Promise<T> {
+	const timer = setInterval(async () => {
+		const p = await Native.progress();
+		onProgress(p && `${p.task} (${p.step}/${p.steps}${p.percent ? `, ${p.percent}%` : ""})`);
+	}, 500);
+	try {
+		return await action();
+	} finally {
+		clearInterval(timer);
+		onProgress(null);
+	}
+}
+
+// The Venjector that built this, filled in when copying core, see copyCore in steps.go
+const builtBy = "$VENJECTOR-SELFPATH";
+
 const settings = definePluginSettings({
 	path: {
 		type: OptionType.STRING,
 		description: "Where to find Venjector",
 		hidden: true,
-		default: "/home/tizu/go/bin/venjector",
+		default: builtBy,
 	},
 	visualize: {
 		type: OptionType.BOOLEAN,
@@ -25,6 +45,111 @@ const settings = definePluginSettings({
 	},
 });
 
+function showFailure(message: string) {
+	Toasts.show({
+		message,
+		id: Toasts.genId(),
+		type: Toasts.Type.FAILURE,
+		options: {
+			position: Toasts.Position.BOTTOM
+		}
+	});
+}
+
+// VenjectorUtils are the buttons on top of the plugins page, patched in by copyCore, see patches.go
+export function VenjectorUtils() {
+	const [reloadProgress, setReloadProgress] = React.useState<string | null>(null);
+
+	const run = (what: string, choice: string, visual: boolean) => {
+		console.info(what, settings.store.path);
+		return Native.run(settings.store.path, choice, visual);
+	};
+
+	return (
+		<>
+			<Forms.FormTitle tag="h5" className={classes(Margins.top20, Margins.bottom8)}>
+				Venjector Utils
+			</Forms.FormTitle>
+
+			<div style={{ display: "flex", gap: "8px" }}> {/* TODO: Use proper component for flex */}
+				<Button
+					size={Button.Sizes.SMALL}
+					onClick={async () => {
+						const result = await withProgress(setReloadProgress, () =>
+							run("Reload plugins", "0", settings.store.visualize));
+						if (!result.success)
+							showFailure(result.result?.errors.at(-1)?.message ?? `Reloading failed: ${result.reason} (${result.code})`);
+						else
+							await new Promise<void>(r => {
+								Alerts.show({
+									title: "Reload success!",
+									body: <>
+										{result.result?.warnings.map(w => <Forms.FormText>Warning: {w}</Forms.FormText>)}
+										<Forms.FormText>Successfully reloaded. Restart now to apply the changes?</Forms.FormText>
+									</>,
+									confirmText: "Restart",
+									cancelText: "Not now!",
+									onConfirm() {
+										relaunch();
+										r();
+									},
+									onCancel: r
+								});
+							});
+					}}
+				>
+					{reloadProgress ?? "Reload plugins"}
+				</Button>
+
+				<Button
+					size={Button.Sizes.SMALL}
+					onClick={() => run("Open plugin folder", "1", false)}
+				>
+					Open plugin folder
+				</Button>
+				<Button
+					size={Button.Sizes.SMALL}
+					onClick={() => run("Open list of remote plugins", "3", false)}
+				>
+					Open list of remote plugins
+				</Button>
+
+				<Button
+					size={Button.Sizes.SMALL}
+					onClick={() => run("Open Venjector", "-1", settings.store.visualize)}
+				>
+					Open Venjector
+				</Button>
+			</div>
+
+			<Forms.FormDivider className={Margins.top20} />
+		</>
+	);
+}
+
+// updateWithVenjector is what the updater's button does instead of pulling, patched in by copyCore. Failures are
+// shown here, if it worked the updater asks to restart.
+export async function updateWithVenjector(onProgress: (text: string | null) => void): Promise<boolean> {
+	const result = await withProgress(onProgress, () =>
+		Native.run(settings.store.path, "0", settings.store.visualize));
+	if (!result.success) {
+		showFailure(`Updating failed: ${result.result?.errors.at(-1)?.message ?? result.reason} :(`);
+		return false;
+	}
+
+	if (result.result?.warnings.length) {
+		Toasts.show({
+			message: `Built ${result.result.commit?.slice(0, 7) ?? "Vencord"} with warnings: ${result.result.warnings.join(", ")}`,
+			id: Toasts.genId(),
+			type: Toasts.Type.MESSAGE,
+			options: {
+				position: Toasts.Position.BOTTOM
+			}
+		});
+	}
+	return true;
+}
+
 export default definePlugin({
 	name: "Venjector",
 	description: "Adds Venjector stuff :3",
@@ -38,9 +163,28 @@ export default definePlugin({
 	settings,
 
 	patches: [],
+	watchTimer: undefined as ReturnType<typeof setInterval> | undefined,
+
+	async start() {
+		// Vencord's own updater would pull and build without your plugins, updates go through Venjector
+		Settings.autoUpdate = false;
+
+		// Settings keep the path from whenever they were first saved, Venjector might have moved since
+		if (!await Native.isVenjector(settings.store.path)) {
+			const fixed = settings.store.path !== builtBy && await Native.isVenjector(builtBy);
+			if (fixed) settings.store.path = builtBy;
+			Toasts.show({
+				message: fixed
+					? `Venjector moved, using ${builtBy} now`
+					: `Can't find Venjector at ${settings.store.path}, reload plugins from Venjector itself`,
+				id: Toasts.genId(),
+				type: fixed ? Toasts.Type.MESSAGE : Toasts.Type.FAILURE,
+				options: {
+					position: Toasts.Position.BOTTOM
+				}
+			});
+		}
 
-	start() {
-		console.log(Native); // unused my ass
 		Toasts.show({
 			message: "Venjected :3",
 			id: Toasts.genId(),
@@ -49,5 +193,26 @@ export default definePlugin({
 				position: Toasts.Position.BOTTOM
 			}
 		});
+
+		// Tell about new builds from 'venjector watch'
+		let seen = 0;
+		Native.builtAt().then(t => seen = t);
+		this.watchTimer = setInterval(async () => {
+			const t = await Native.builtAt();
+			if (t <= seen) return;
+			seen = t;
+			Toasts.show({
+				message: "New build ready, restart to use it",
+				id: Toasts.genId(),
+				type: Toasts.Type.SUCCESS,
+				options: {
+					position: Toasts.Position.BOTTOM
+				}
+			});
+		}, 2000);
+	},
+
+	stop() {
+		clearInterval(this.watchTimer);
 	}
 });
@@ -7,40 +7,231 @@
 import { IpcMainInvokeEvent } from "electron";
 
 import { spawn } from "child_process";
+import { accessSync, constants, readFileSync, statSync } from "fs";
+import { connect } from "net";
+import { join } from "path";
+
+// Where a running 'venjector daemon' says how to reach it, filled in when reloading
+const daemonInfoPath = "$VENJECTOR-DAEMONINFO";
+
+// What this was built from, so Venjector is started for the same data, cache and profile
+const builtFor = {
+  configDir: "$VENJECTOR-CONFIGDIR",
+  dataDir: "$VENJECTOR-DATADIR",
+  cacheDir: "$VENJECTOR-CACHEDIR",
+  profile: "$VENJECTOR-PROFILE",
+};
+
+// Keep in sync with the exit codes in errors.go
+const exitReasons: Record<number, string> = {
+  0: "Success",
+  1: "Unknown error",
+  2: "Canceled",
+  10: "Network error",
+  11: "Missing prerequisite",
+  12: "Build failed",
+  13: "Filesystem error",
+  14: "Permission denied",
+  15: "Another Venjector run is in progress",
+};
+
+// Error categories as the daemon reports them, by exit code
+const categoryCodes: Record<string, number> = {
+  canceled: 2,
+  network: 10,
+  prerequisite: 11,
+  build: 12,
+  filesystem: 13,
+  permission: 14,
+  busy: 15,
+};
+
+// How far along the current run is, see progressEvent in daemon.go
+export interface Progress {
+  step: number;
+  steps: number;
+  task: string;
+  percent: number;
+}
+
+let lastProgress: Progress | null = null;
+
+export function progress(_: IpcMainInvokeEvent): Progress | null {
+  return lastProgress;
+}
+
+function trackProgress(line: string): boolean {
+  try {
+    const event = JSON.parse(line);
+    if (event.event !== "progress") return false;
+    lastProgress = event;
+    return true;
+  } catch {
+    return false;
+  }
+}
+
+// What Venjector prints with --json, see result.go
+export interface VenjectorResult {
+  success: boolean;
+  exitCode: number;
+  choice: number;
+  durationMs: number;
+  steps: { task: string; durationMs: number; attempts: number; skipped?: boolean; error?: string; }[];
+  warnings: string[];
+  errors: { category: string; message: string; }[];
+  commit?: string;
+}
+
+export interface RunResult {
+  success: boolean;
+  code: number;
+  reason: string;
+  result?: VenjectorResult;
+}
+
+// builtAt is when 'venjector watch' last rebuilt this build, 0 if it didn't. Native code is bundled into dist,
+// where the stamp is too, see watch.go.
+export function builtAt(_: IpcMainInvokeEvent): number {
+  try {
+    return JSON.parse(readFileSync(join(__dirname, "venjector-build.json"), "utf8")).time;
+  } catch {
+    return 0;
+  }
+}
+
+// isVenjector checks there's something we can run at p. Whether it really is Venjector is up to the user.
+export function isVenjector(_: IpcMainInvokeEvent, p: string): boolean {
+  try {
+    accessSync(p, constants.X_OK);
+    return statSync(p).isFile();
+  } catch {
+    return false;
+  }
+}
+
+// callDaemon makes one call to a running daemon. It rejects if there is none, or the call fails.
+function callDaemon(method: string, params: object): Promise<any> {
+  return new Promise((resolve, reject) => {
+    let info: { network: string; address: string; token: string; };
+    try {
+      info = JSON.parse(readFileSync(daemonInfoPath, "utf8"));
+    } catch (e) {
+      return reject(e);
+    }
+
+    const colon = info.address.lastIndexOf(":");
+    const socket = info.network === "unix"
+      ? connect(info.address)
+      : connect(Number(info.address.slice(colon + 1)), info.address.slice(0, colon).replace(/^\[|\]$/g, ""));
+    let buffer = "";
+
+    socket.on("error", reject);
+    socket.on("close", () => reject(new Error("daemon went away")));
+    socket.on("connect", () => {
+      socket.write(JSON.stringify({ jsonrpc: "2.0", id: 0, method: "auth", params: { token: info.token } }) + "\n");
+      socket.write(JSON.stringify({ jsonrpc: "2.0", id: 1, method, params }) + "\n");
+    });
+    socket.on("data", data => {
+      buffer += data;
+      let i: number;
+      while ((i = buffer.indexOf("\n")) >= 0) {
+        const message = JSON.parse(buffer.slice(0, i));
+        buffer = buffer.slice(i + 1);
+
+        if (message.method === "progress") lastProgress = message.params;
+        else if (message.error) {
+          socket.destroy();
+          reject(message.error);
+        } else if (message.id === 1) {
+          socket.destroy();
+          resolve(message.result);
+        }
+      }
+    });
+  });
+}
 
 export async function run(
   _: IpcMainInvokeEvent,
   p: string,
   n: string,
   v: boolean
-) {
-  console.log(
-    "Command to be executed:",
-    [
-      p,
-      "--auto-choice=" + n,
-      v ? "--visual=true" : "--visual=false",
-      n != "-1" ? "--tipless=true" : "--tipless=false",
-    ].join(" ")
-  );
-
-  const child = spawn(p, [
+): Promise<RunResult> {
+  lastProgress = null;
+
+  // Reloading can go through a daemon, if one is running. The rest needs dialogs anyway.
+  if (n === "0") {
+    try {
+      const result: VenjectorResult = await callDaemon("build", { visual: v });
+      console.log("daemon result:", result);
+      lastProgress = null;
+      return { success: result.success, code: result.exitCode, reason: exitReasons[result.exitCode] ?? exitReasons[1], result };
+    } catch (e: any) {
+      if (e?.code === -32000) { // the daemon is there, but failed
+        lastProgress = null;
+        const category: string = e.data?.category ?? "unknown";
+        const code = categoryCodes[category] ?? 1;
+        const result: VenjectorResult = {
+          success: false,
+          exitCode: code,
+          choice: 0,
+          durationMs: 0,
+          steps: [],
+          warnings: [],
+          errors: [{ category, message: e.data?.message ?? e.message }],
+        };
+        return { success: false, code, reason: exitReasons[code], result };
+      }
+      console.log("No daemon, spawning Venjector:", e);
+    }
+  }
+
+  const args = [
     "--auto-choice=" + n,
     v ? "--visual=true" : "--visual=false",
     n != "-1" ? "--tipless=true" : "--tipless=false",
-  ]);
+    "--json",
+    "--events",
+  ];
+  console.log("Command to be executed:", [p, ...args].join(" "));
+
+  const child = spawn(p, args, {
+    env: {
+      ...process.env,
+      VENJECTOR_CONFIG_HOME: builtFor.configDir,
+      VENJECTOR_DATA_HOME: builtFor.dataDir,
+      VENJECTOR_CACHE_HOME: builtFor.cacheDir,
+      VENJECTOR_PROFILE: builtFor.profile,
+    },
+  });
+
+  let stdout = "";
 
   child.stderr.on("data", (data) => {
     console.log(`stderr: ${data}`);
   });
   child.stdout.on("data", (data) => {
-    console.log(`stdout: ${data}`);
+    stdout += data;
+    let i: number;
+    while ((i = stdout.indexOf("\n")) >= 0 && trackProgress(stdout.slice(0, i)))
+      stdout = stdout.slice(i + 1);
   });
 
-  const exitCode = await new Promise((resolve, reject) => {
-    child.on("close", resolve);
+  const exitCode = await new Promise<number>((resolve, reject) => {
+    child.on("close", code => resolve(code ?? 1));
   });
-  console.log(`exit code: ${exitCode}`);
+  const reason = exitReasons[exitCode] ?? exitReasons[1];
+  console.log(`exit code: ${exitCode} (${reason})`);
+
+  let result: VenjectorResult | undefined;
+  try {
+    result = JSON.parse(stdout.trim().split("\n").pop()!);
+    console.log("result:", result);
+  } catch {
+    console.log(`stdout: ${stdout}`);
+  }
 
-  return exitCode === 0;
+  lastProgress = null;
+  return { success: exitCode === 0, code: exitCode, reason, result };
 }
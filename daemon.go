@@ -0,0 +1,437 @@
+/*
+	Venjector: Copyright (C) 2023 tizu69
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+// The daemon lets the in-client plugin talk to a running Venjector instead of spawning one per click. It speaks
+// JSON-RPC 2.0, one message per line, on a Unix socket or (on Windows, or with --listen) a loopback TCP port.
+// daemon.json in the data directory says where, along with a token every connection has to 'auth' with first.
+// Builds still run as a child process each, the daemon passes their progress on as 'progress' notifications.
+
+// daemonInfo is daemon.json. It holds the token, so only we can read it.
+type daemonInfo struct {
+	Network string `json:"network"` // unix or tcp
+	Address string `json:"address"`
+	Token   string `json:"token"`
+	PID     int    `json:"pid"`
+}
+
+// JSON-RPC error codes of our own, next to the exit codes the errors carry in their data
+const (
+	rpcParseError     = -32700
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcFailed         = -32000
+	rpcUnauthorized   = -32001
+)
+
+type rpcRequest struct {
+	ID     json.RawMessage `json:"id,omitempty"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params,omitempty"`
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+type rpcNotification struct {
+	JSONRPC string      `json:"jsonrpc"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params"`
+}
+
+// progressEvent is how far along a run is. With --events, runs print these to stdout before their result.
+type progressEvent struct {
+	Event   string `json:"event"` // always "progress"
+	Step    int    `json:"step"`
+	Steps   int    `json:"steps"`
+	Task    string `json:"task"`
+	Percent int    `json:"percent"` // within the step
+}
+
+// daemonStatus is what the 'status' method returns.
+type daemonStatus struct {
+	Profile  string         `json:"profile"`
+	Commit   string         `json:"commit,omitempty"`
+	Building bool           `json:"building"`
+	Clients  []clientStatus `json:"clients"`
+}
+
+type daemon struct {
+	token    string
+	building atomic.Bool
+
+	mu    sync.Mutex
+	conns map[*rpcConn]bool
+}
+
+type rpcConn struct {
+	conn   net.Conn
+	mu     sync.Mutex  // one message at a time
+	authed atomic.Bool // broadcast reads it from other goroutines
+}
+
+func daemonInfoPath() string {
+	return filepath.Join(getDataPath(), "daemon.json")
+}
+
+func readDaemonInfo() (daemonInfo, error) {
+	info := daemonInfo{}
+	data, err := os.ReadFile(daemonInfoPath())
+	if err != nil {
+		return info, err
+	}
+	return info, json.Unmarshal(data, &info)
+}
+
+// emitProgress tells whoever started us with --events how far along we are.
+func emitProgress(percent int) {
+	if !cli.Events || progress == nil {
+		return
+	}
+	json.NewEncoder(os.Stdout).Encode(progressEvent{
+		Event:   "progress",
+		Step:    currentStep,
+		Steps:   progress.MaxValue()/progressScale - 1,
+		Task:    currentTask,
+		Percent: percent,
+	})
+}
+
+// runDaemon is 'venjector daemon'. It serves until it's stopped with Ctrl-C or SIGTERM.
+func runDaemon() error {
+	if info, err := readDaemonInfo(); err == nil {
+		if conn, err := net.DialTimeout(info.Network, info.Address, time.Second); err == nil {
+			conn.Close()
+			return wrapError(categoryBusy, "A daemon is already running", fmt.Errorf("PID %d at %s", info.PID, info.Address))
+		}
+	}
+
+	network, address := "unix", filepath.Join(getDataPath(), "venjector.sock")
+	if cli.Daemon.Listen != "" {
+		network, address = "tcp", cli.Daemon.Listen
+	} else if runtime.GOOS == "windows" {
+		network, address = "tcp", "127.0.0.1:0"
+	}
+
+	if network == "tcp" {
+		// Anyone who can reach the port could make us build things, so not the network
+		host, _, err := net.SplitHostPort(address)
+		if ip := net.ParseIP(host); err != nil || ip == nil || !ip.IsLoopback() {
+			return wrapError(categoryPrerequisite, "The daemon only listens on loopback addresses, like 127.0.0.1:7531",
+				errors.New(address))
+		}
+	} else {
+		if err := os.MkdirAll(getDataPath(), 0755); err != nil {
+			return wrapError(categoryFilesystem, "Failed to create "+getDataPath(), err)
+		}
+		os.Remove(address) // left over from a daemon that didn't get to clean up, we checked it's not there
+	}
+
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		return wrapError(categoryPrerequisite, "Failed to listen on "+address, err)
+	}
+	defer listener.Close()
+	if network == "unix" {
+		defer os.Remove(address)
+		if err := os.Chmod(address, 0600); err != nil {
+			return wrapError(categoryFilesystem, "Failed to protect "+address, err)
+		}
+	}
+
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return wrapError(categoryUnknown, "Failed to make a token", err)
+	}
+
+	d := &daemon{token: hex.EncodeToString(token), conns: map[*rpcConn]bool{}}
+	info := daemonInfo{Network: network, Address: listener.Addr().String(), Token: d.token, PID: os.Getpid()}
+	data, err := json.MarshalIndent(info, "", "\t")
+	if err != nil {
+		return wrapError(categoryUnknown, "Failed to marshal daemon.json", err)
+	}
+	if err := os.WriteFile(daemonInfoPath(), data, 0600); err != nil {
+		return wrapError(categoryFilesystem, "Failed to write daemon.json", err)
+	}
+	defer os.Remove(daemonInfoPath())
+
+	go func() {
+		<-runCtx.Done()
+		listener.Close()
+	}()
+
+	log.Info("Daemon listening", "network", info.Network, "address", info.Address, "profile", currentProfile())
+	for {
+		conn, err := listener.Accept()
+		if runCtx.Err() != nil {
+			log.Info("Daemon stopped")
+			return nil
+		} else if err != nil {
+			return wrapError(categoryUnknown, "Failed to accept connection", err)
+		}
+		go d.serve(&rpcConn{conn: conn})
+	}
+}
+
+func (d *daemon) serve(c *rpcConn) {
+	d.mu.Lock()
+	d.conns[c] = true
+	d.mu.Unlock()
+	defer func() {
+		d.mu.Lock()
+		delete(d.conns, c)
+		d.mu.Unlock()
+		c.conn.Close()
+	}()
+
+	reader := bufio.NewReader(c.conn)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			return
+		}
+
+		req := rpcRequest{}
+		if err := json.Unmarshal(line, &req); err != nil {
+			c.reply(nil, nil, &rpcError{Code: rpcParseError, Message: err.Error()})
+			continue
+		}
+
+		// Auth has to be done before anything else is read, so it's not handled concurrently
+		if req.Method == "auth" {
+			params := struct {
+				Token string `json:"token"`
+			}{}
+			json.Unmarshal(req.Params, &params)
+			c.authed.Store(subtle.ConstantTimeCompare([]byte(params.Token), []byte(d.token)) == 1)
+			if !c.authed.Load() {
+				c.reply(req.ID, nil, &rpcError{Code: rpcUnauthorized, Message: "Wrong token"})
+				return
+			}
+			c.reply(req.ID, true, nil)
+			continue
+		} else if !c.authed.Load() {
+			c.reply(req.ID, nil, &rpcError{Code: rpcUnauthorized, Message: "Call 'auth' first"})
+			return
+		}
+
+		go func() {
+			res, rpcErr := d.call(req.Method, req.Params)
+			if req.ID != nil {
+				c.reply(req.ID, res, rpcErr)
+			}
+		}()
+	}
+}
+
+// A client that doesn't read what we send in time is dropped, so it can't hold up the others
+const sendTimeout = 5 * time.Second
+
+func (c *rpcConn) send(message interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(sendTimeout))
+	if err := json.NewEncoder(c.conn).Encode(message); err != nil {
+		log.Warn("Failed to send to daemon client, dropping it", "err", err)
+		c.conn.Close()
+	}
+}
+
+func (c *rpcConn) reply(id json.RawMessage, res interface{}, rpcErr *rpcError) {
+	if id == nil {
+		id = json.RawMessage("null")
+	}
+	response := rpcResponse{JSONRPC: "2.0", ID: id, Error: rpcErr}
+	if rpcErr == nil {
+		data, err := json.Marshal(res)
+		if err != nil {
+			response.Error = &rpcError{Code: rpcFailed, Message: err.Error()}
+		} else {
+			response.Result = data
+		}
+	}
+	c.send(response)
+}
+
+// broadcast sends a notification to every authenticated client.
+// Sending happens without d.mu, so a slow client doesn't keep others from connecting or leaving meanwhile.
+func (d *daemon) broadcast(method string, params interface{}) {
+	d.mu.Lock()
+	conns := []*rpcConn{}
+	for c := range d.conns {
+		if c.authed.Load() {
+			conns = append(conns, c)
+		}
+	}
+	d.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, c := range conns {
+		wg.Add(1)
+		go func(c *rpcConn) {
+			defer wg.Done()
+			c.send(rpcNotification{JSONRPC: "2.0", Method: method, Params: params})
+		}(c)
+	}
+	wg.Wait() // before the next one, so they arrive in order
+}
+
+func (d *daemon) call(method string, raw json.RawMessage) (interface{}, *rpcError) {
+	log.Info("Daemon call", "method", method)
+
+	var res interface{}
+	var err error
+	switch method {
+	case "build":
+		params := struct {
+			Visual bool `json:"visual"`
+		}{}
+		if len(raw) != 0 && json.Unmarshal(raw, &params) != nil {
+			return nil, &rpcError{Code: rpcInvalidParams, Message: "Expected {visual}"}
+		}
+		res, err = d.build(params.Visual)
+	case "status":
+		status := daemonStatus{Profile: currentProfile(), Commit: builtCommit(), Building: d.building.Load(), Clients: []clientStatus{}}
+		for _, c := range findClients() {
+			status.Clients = append(status.Clients, getStatus(c))
+		}
+		res = status
+	case "plugins.list":
+		res, err = listPlugins()
+	case "remotes.add":
+		params := struct {
+			URL string `json:"url"`
+		}{}
+		if json.Unmarshal(raw, &params) != nil || params.URL == "" {
+			return nil, &rpcError{Code: rpcInvalidParams, Message: "Expected {url}"}
+		}
+		res, err = addRemote(params.URL)
+	default:
+		return nil, &rpcError{Code: rpcMethodNotFound, Message: "No method " + method}
+	}
+
+	if err != nil {
+		category := categoryOf(err)
+		log.Error("Daemon call failed", "method", method, "category", category, "err", err)
+		return nil, &rpcError{Code: rpcFailed, Message: err.Error(), Data: errorResult{Category: category.String(), Message: err.Error()}}
+	}
+	return res, nil
+}
+
+// build reloads plugins in a child run, passing its progress on, and returns its result.
+func (d *daemon) build(visual bool) (*runResult, error) {
+	if !d.building.CompareAndSwap(false, true) {
+		return nil, wrapError(categoryBusy, "Another Venjector run is in progress", errors.New("the daemon is already building"))
+	}
+	defer d.building.Store(false)
+
+	self, err := os.Executable()
+	if err != nil {
+		return nil, wrapError(categoryFilesystem, "Failed to get self path", err)
+	}
+
+	command := newCommand(self, "--auto-choice=0", "--tipless", "--json", "--events", "--visual="+strconv.FormatBool(visual))
+	command.Stderr = os.Stderr
+	// The child should see what we see, whatever flags we got it from
+	command.Env = append(os.Environ(),
+		"VENJECTOR_PROFILE="+currentProfile(),
+		"VENJECTOR_CONFIG_HOME="+getConfigPath(),
+		"VENJECTOR_DATA_HOME="+getDataPath(),
+		"VENJECTOR_CACHE_HOME="+getCachePath(),
+		"VENJECTOR_RUNNER="+cli.Runner,
+		"VENJECTOR_OFFLINE="+strconv.FormatBool(cli.Offline),
+		"VENJECTOR_MANAGED_TOOLCHAIN="+strconv.FormatBool(cli.ManagedToolchain))
+
+	stdout, err := command.StdoutPipe()
+	if err != nil {
+		return nil, wrapError(categoryUnknown, "Failed to start build", err)
+	}
+	if err := command.Start(); err != nil {
+		return nil, wrapError(categoryUnknown, "Failed to start build", err)
+	}
+
+	var last []byte
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		event := progressEvent{}
+		if json.Unmarshal(scanner.Bytes(), &event) == nil && event.Event == "progress" {
+			d.broadcast("progress", event)
+			continue
+		}
+		last = append(last[:0], scanner.Bytes()...)
+	}
+	waitErr := command.Wait()
+
+	res := &runResult{}
+	if err := json.Unmarshal(last, res); err != nil {
+		return nil, wrapError(categoryUnknown, "Build ended without a result", errors.Join(waitErr, err))
+	}
+	return res, nil
+}
+
+// listPlugins is the 'plugins.list' method: local plugins by directory name, and remote ones by URL.
+func listPlugins() (interface{}, error) {
+	remote, err := readRemoteList()
+	if err != nil {
+		return nil, err
+	}
+
+	local := []string{}
+	entries, err := os.ReadDir(filepath.Join(getProfilePath(), "overrides", "src", "userplugins"))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, wrapError(categoryFilesystem, "Failed to list plugins", err)
+	}
+	for _, entry := range entries {
+		local = append(local, entry.Name())
+	}
+
+	return struct {
+		Local  []string `json:"local"`
+		Remote []string `json:"remote"`
+	}{local, remote}, nil
+}
@@ -0,0 +1,388 @@
+/*
+	Venjector: Copyright (C) 2023 tizu69
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/charmbracelet/log"
+)
+
+type check struct {
+	Name     string `json:"name"`
+	Found    string `json:"found"`
+	Required string `json:"required"`
+	Ok       bool   `json:"ok"`
+	Fix      string `json:"fix,omitempty"`
+}
+
+// vencordPackage is the part of Vencord's package.json we care about.
+type vencordPackage struct {
+	PackageManager string            `json:"packageManager"`
+	Engines        map[string]string `json:"engines"`
+}
+
+var versionPattern = regexp.MustCompile(`(\d+)(?:\.(\d+))?(?:\.(\d+))?`)
+
+// version is major, minor, patch. precision is how many of those were actually given, so that "18" can mean 18.x.x.
+type version struct {
+	parts     [3]int
+	precision int
+}
+
+func parseVersion(s string) (version, bool) {
+	m := versionPattern.FindStringSubmatch(s)
+	if m == nil {
+		return version{}, false
+	}
+
+	v := version{}
+	for i, part := range m[1:] {
+		if part == "" {
+			break
+		}
+		v.parts[i], _ = strconv.Atoi(part)
+		v.precision++
+	}
+	return v, true
+}
+
+func (v version) String() string {
+	return fmt.Sprintf("%d.%d.%d", v.parts[0], v.parts[1], v.parts[2])
+}
+
+func compareVersions(a, b version) int {
+	for i := range a.parts {
+		if a.parts[i] != b.parts[i] {
+			return a.parts[i] - b.parts[i]
+		}
+	}
+	return 0
+}
+
+// satisfies checks v against an npm style range, like ">=18", "^8.10.2 <9", "16.x || >= 18" or "18 - 20".
+func satisfies(v version, constraint string) bool {
+	for _, alternative := range strings.Split(constraint, "||") {
+		ok := true
+		for _, comparator := range comparators(alternative) {
+			if !satisfiesComparator(v, comparator) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+// comparators splits one alternative of a range into comparators. An operator written apart from its version is
+// glued back onto it, and a hyphen range "a - b" becomes ">=a" and "<=b".
+func comparators(alternative string) []string {
+	fields := strings.Fields(alternative)
+	result := []string{}
+	for i := 0; i < len(fields); i++ {
+		switch {
+		case i+2 < len(fields) && fields[i+1] == "-":
+			result = append(result, ">="+fields[i], "<="+fields[i+2])
+			i += 2
+		case i+1 < len(fields) && strings.Trim(fields[i], "<>=^~") == "":
+			result = append(result, fields[i]+fields[i+1])
+			i++
+		default:
+			result = append(result, fields[i])
+		}
+	}
+	return result
+}
+
+func satisfiesComparator(v version, comparator string) bool {
+	if comparator == "*" || comparator == "x" {
+		return true
+	}
+
+	op := strings.TrimRight(comparator, "0123456789.xX*")
+	want, ok := parseVersion(comparator[len(op):])
+	if !ok {
+		log.Warn("Ignoring version requirement we don't understand", "requirement", comparator)
+		return true
+	}
+	cmp := compareVersions(v, want)
+
+	// Only as exact as it was written, so 18 is anything 18.x.x
+	same := true
+	for i := 0; i < want.precision; i++ {
+		if v.parts[i] != want.parts[i] {
+			same = false
+		}
+	}
+
+	switch op {
+	case ">=":
+		return cmp >= 0
+	case ">":
+		return cmp > 0 && !same
+	case "<=":
+		return cmp <= 0 || same
+	case "<":
+		return cmp < 0
+	case "^":
+		if want.parts[0] == 0 && want.precision > 1 {
+			return cmp >= 0 && v.parts[0] == 0 && v.parts[1] == want.parts[1]
+		}
+		return cmp >= 0 && v.parts[0] == want.parts[0]
+	case "~":
+		if want.precision < 2 {
+			return cmp >= 0 && v.parts[0] == want.parts[0]
+		}
+		return cmp >= 0 && v.parts[0] == want.parts[0] && v.parts[1] == want.parts[1]
+	case "", "=":
+		return same
+	}
+
+	log.Warn("Ignoring version requirement we don't understand", "requirement", comparator)
+	return true
+}
+
+// readVencordPackage reads the package.json of a Vencord checkout, repoDir or stagingDir.
+func readVencordPackage(dir string) (*vencordPackage, error) {
+	data, err := os.ReadFile(filepath.Join(getBuildPath(), dir, "package.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	pkg := &vencordPackage{}
+	if err := json.Unmarshal(data, pkg); err != nil {
+		return nil, err
+	}
+	return pkg, nil
+}
+
+// toolVersion runs name with args and finds a version in its output.
+func toolVersion(name string, args ...string) (string, error) {
+	command := newCommand(name, args...)
+
+	buf := new(bytes.Buffer)
+	command.Stdout = buf
+
+	if err := command.Run(); err != nil {
+		return "", err
+	}
+
+	v, ok := parseVersion(buf.String())
+	if !ok {
+		return "", fmt.Errorf("no version in %q", strings.TrimSpace(buf.String()))
+	}
+	return v.String(), nil
+}
+
+func checkTool(name, command, required, fix string) check {
+	c := check{Name: name, Required: required, Fix: fix}
+	if c.Required == "" {
+		c.Required = "any"
+	}
+
+	found, err := toolVersion(command, "--version")
+	if err != nil {
+		c.Found = "missing"
+		return c
+	}
+	c.Found = found
+
+	v, _ := parseVersion(found)
+	c.Ok = c.Required == "any" || satisfies(v, c.Required)
+	if c.Ok {
+		c.Fix = ""
+	}
+	return c
+}
+
+func checkDialogs() check {
+	c := check{Name: "dialogs", Required: "any"}
+
+	var backends []string
+	switch runtime.GOOS {
+	case "linux":
+		backends = []string{"zenity", "matedialog", "qarma"}
+		c.Fix = "Install one of zenity, matedialog or qarma"
+	case "darwin":
+		backends = []string{"osascript"}
+		c.Fix = "osascript should come with macOS, check your PATH"
+	default:
+		// Windows dialogs are built in
+		c.Found, c.Ok, c.Fix = "built in", true, ""
+		return c
+	}
+
+	for _, backend := range backends {
+		if _, err := exec.LookPath(backend); err == nil {
+			c.Found, c.Ok, c.Fix = backend, true, ""
+			return c
+		}
+	}
+	c.Found = "missing"
+	return c
+}
+
+// runChecks checks everything we need, against what the last Vencord build requires.
+func runChecks() []check {
+	nodeRequired, pnpmRequired := "", ""
+
+	pkg, err := readVencordPackage(repoDir)
+	if err != nil {
+		log.Info("No Vencord checkout to take version requirements from", "err", err)
+	} else {
+		nodeRequired = pkg.Engines["node"]
+		pnpmRequired = pkg.Engines["pnpm"]
+
+		if name, ver, ok := strings.Cut(pkg.PackageManager, "@"); ok && name == "pnpm" {
+			ver, _, _ = strings.Cut(ver, "+") // drop the hash corepack wants
+			pnpmRequired = strings.TrimSpace(pnpmRequired + " ^" + ver)
+		}
+	}
+
+	nodeFix := strings.Join(strings.Fields("Install Node.js "+nodeRequired+" from https://nodejs.org or your package manager"), " ")
+
+	checks := []check{}
+	switch {
+	case usesManagedToolchain():
+		checks = append(checks, checkManagedToolchain(nodeRequired, pnpmRequired)...)
+	case cli.Runner == "corepack":
+		// corepack fetches whatever pnpm Vencord wants by itself
+		checks = append(checks,
+			checkTool("node", "node", nodeRequired, nodeFix),
+			checkTool("corepack", "corepack", "", "corepack comes with Node.js 16.9 and newer, update Node.js"))
+	case cli.Runner == "dry-run":
+	default:
+		checks = append(checks,
+			checkTool("node", "node", nodeRequired, nodeFix),
+			checkTool("pnpm", "pnpm", pnpmRequired,
+				"Run 'corepack enable' or 'npm install -g pnpm', see https://pnpm.io/installation"))
+	}
+
+	return append(checks,
+		checkTool("git", "git", "", "Install Git from https://git-scm.com or your package manager"),
+		checkDialogs())
+}
+
+// checkManagedToolchain checks our own Node.js and pnpm. Not having them yet is fine, the next rebuild gets them.
+func checkManagedToolchain(nodeRequired, pnpmRequired string) []check {
+	node := check{Name: "node (managed)", Required: nodeRequired, Found: "not provisioned", Ok: true}
+	pnpm := check{Name: "pnpm (managed)", Required: pnpmRequired, Found: "not provisioned", Ok: true}
+	if node.Required == "" {
+		node.Required = "any"
+	}
+	if pnpm.Required == "" {
+		pnpm.Required = "any"
+	}
+
+	tc, err := loadToolchain()
+	if err != nil {
+		return []check{node, pnpm}
+	}
+
+	if found, err := toolVersion(tc.Node, "--version"); err != nil {
+		node.Found, node.Ok, node.Fix = "broken", false, "Remove "+getToolchainPath()+" and reload plugins"
+	} else {
+		v, _ := parseVersion(found)
+		node.Found = found
+		node.Ok = node.Required == "any" || satisfies(v, node.Required)
+		if !node.Ok {
+			node.Fix = "Reload plugins to provision a matching version"
+		}
+	}
+
+	v, _ := parseVersion(tc.PnpmVersion)
+	pnpm.Found = tc.PnpmVersion
+	pnpm.Ok = pnpm.Required == "any" || satisfies(v, pnpm.Required)
+	if !pnpm.Ok {
+		pnpm.Fix = "Reload plugins to provision a matching version"
+	}
+
+	return []check{node, pnpm}
+}
+
+func printChecks(w io.Writer, checks []check) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "\tTOOL\tFOUND\tREQUIRED\tFIX")
+	for _, c := range checks {
+		mark := "✓"
+		if !c.Ok {
+			mark = "✗"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", mark, c.Name, c.Found, c.Required, c.Fix)
+	}
+	tw.Flush()
+}
+
+// ensurePrerequisites is the startup check, it fails with every problem at once.
+func ensurePrerequisites() error {
+	checks := runChecks()
+
+	problems := []string{}
+	for _, c := range checks {
+		log.Info("Checked", "tool", c.Name, "found", c.Found, "required", c.Required, "ok", c.Ok)
+		if !c.Ok {
+			problems = append(problems, fmt.Sprintf("%s: found %s, requires %s. %s", c.Name, c.Found, c.Required, c.Fix))
+		}
+	}
+
+	if len(problems) != 0 {
+		return wrapError(categoryPrerequisite, "Missing prerequisites (see 'venjector doctor')",
+			errors.New(strings.Join(problems, "\n")))
+	}
+	return nil
+}
+
+// doctor is the 'venjector doctor' command.
+func doctor() error {
+	checks := runChecks()
+
+	if cli.JSON {
+		if err := json.NewEncoder(os.Stdout).Encode(checks); err != nil {
+			return wrapError(categoryUnknown, "Failed to print checks", err)
+		}
+	} else {
+		printChecks(os.Stdout, checks)
+	}
+
+	failed := []string{}
+	for _, c := range checks {
+		if !c.Ok {
+			failed = append(failed, c.Name)
+		}
+	}
+
+	if len(failed) != 0 {
+		return wrapError(categoryPrerequisite, "Not all prerequisites are met", errors.New(strings.Join(failed, ", ")))
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+/*
+	Venjector: Copyright (C) 2023 tizu69
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import "testing"
+
+func TestSatisfies(t *testing.T) {
+	for _, c := range []struct {
+		version, constraint string
+		want                bool
+	}{
+		{"18.17.1", ">=18", true},
+		{"16.20.0", ">=18", false},
+		{"18.0.0", ">= 18.0.0", true},
+		{"17.9.9", ">= 18.0.0", false},
+		{"20.1.0", ">= 18 < 20", false},
+		{"19.1.0", ">= 18 < 20", true},
+		{"8.10.2", "^8.10.2", true},
+		{"8.15.0", "^8.10.2", true},
+		{"9.0.0", "^8.10.2", false},
+		{"8.9.0", "^8.10.2", false},
+		{"0.3.5", "^0.3.1", true},
+		{"0.4.0", "^0.3.1", false},
+		{"8.10.5", "~8.10.2", true},
+		{"8.11.0", "~8.10.2", false},
+		{"8.11.0", "~8", true},
+		{"16.4.0", "16.x || >=18", true},
+		{"17.0.0", "16.x || >=18", false},
+		{"20.0.0", "16.x || >= 18", true},
+		{"17.0.0", "16.x||>=18", false},
+		{"18.5.0", "18 - 20", true},
+		{"20.9.9", "18 - 20", true},
+		{"21.0.0", "18 - 20", false},
+		{"17.9.9", "18.0.0 - 20.1", false},
+		{"20.1.7", "18.0.0 - 20.1", true},
+		{"18.1.0", ">18", false},
+		{"19.0.0", ">18", true},
+		{"18.9.0", "<=18", true},
+		{"19.0.0", "<=18", false},
+		{"18.17.1", "18.17.1", true},
+		{"18.17.2", "=18.17.1", false},
+		{"1.0.0", "*", true},
+	} {
+		v, ok := parseVersion(c.version)
+		if !ok {
+			t.Fatalf("can't parse %s", c.version)
+		}
+		if got := satisfies(v, c.constraint); got != c.want {
+			t.Errorf("%s satisfies %q is %t, want %t", c.version, c.constraint, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+/*
+	Venjector: Copyright (C) 2023 tizu69
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/ncruces/zenity"
+)
+
+type errorCategory int
+
+const (
+	categoryUnknown errorCategory = iota
+	categoryNetwork
+	categoryPrerequisite
+	categoryBuild
+	categoryFilesystem
+	categoryPermission
+	categoryCanceled
+	categoryBusy
+)
+
+// Process exit codes, one per error category. They're documented in README.md and
+// core/pluginNative.ts knows about them, keep all three in sync!
+const (
+	exitOK           = 0
+	exitUnknown      = 1
+	exitCanceled     = 2
+	exitNetwork      = 10
+	exitPrerequisite = 11
+	exitBuild        = 12
+	exitFilesystem   = 13
+	exitPermission   = 14
+	exitBusy         = 15
+)
+
+const networkRetries = 3
+
+func (c errorCategory) String() string {
+	switch c {
+	case categoryNetwork:
+		return "network"
+	case categoryPrerequisite:
+		return "prerequisite"
+	case categoryBuild:
+		return "build"
+	case categoryFilesystem:
+		return "filesystem"
+	case categoryPermission:
+		return "permission"
+	case categoryCanceled:
+		return "canceled"
+	case categoryBusy:
+		return "busy"
+	}
+	return "unknown"
+}
+
+func (c errorCategory) exitCode() int {
+	switch c {
+	case categoryNetwork:
+		return exitNetwork
+	case categoryPrerequisite:
+		return exitPrerequisite
+	case categoryBuild:
+		return exitBuild
+	case categoryFilesystem:
+		return exitFilesystem
+	case categoryPermission:
+		return exitPermission
+	case categoryCanceled:
+		return exitCanceled
+	case categoryBusy:
+		return exitBusy
+	}
+	return exitUnknown
+}
+
+// stepError is an error with a category and a human readable description of what we were trying to do.
+type stepError struct {
+	category    errorCategory
+	task        string
+	err         error
+	unskippable bool
+}
+
+func (e *stepError) Error() string {
+	return e.task + ": " + e.err.Error()
+}
+
+func (e *stepError) Unwrap() error {
+	return e.err
+}
+
+// wrapError wraps err with a category and task. A nil err stays nil, so it can wrap any call directly.
+func wrapError(category errorCategory, task string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &stepError{category: category, task: task, err: err}
+}
+
+// wrapUnskippable is wrapError for failures that can't be skipped, whatever their category, as going on would
+// leave a broken build behind.
+func wrapUnskippable(category errorCategory, task string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &stepError{category: category, task: task, err: err, unskippable: true}
+}
+
+// categoryOf returns the category of the outermost stepError in err's chain.
+// Permission errors from the OS are always reported as such, whatever the step thought it was doing.
+func categoryOf(err error) errorCategory {
+	if errors.Is(err, fs.ErrPermission) {
+		return categoryPermission
+	}
+
+	var se *stepError
+	if errors.As(err, &se) {
+		return se.category
+	}
+	return categoryUnknown
+}
+
+// skippable is whether the user may go on without the task that failed with err.
+func skippable(err error) bool {
+	var se *stepError
+	if errors.As(err, &se) && se.unskippable {
+		return false
+	}
+
+	switch categoryOf(err) {
+	case categoryNetwork, categoryBuild, categoryUnknown:
+		return true
+	}
+	return false
+}
+
+// unattended is whether this run was started by a script or the client, with nobody to answer dialogs.
+func unattended() bool {
+	return cli.AutoChoice != -1 || cli.Tipless || cli.JSON || cli.Events
+}
+
+// handleError decides what to do about a failed task. It returns true if the task should be retried
+// and false if it should be skipped. If the run should be aborted, it doesn't return at all.
+func handleError(task string, err error, attempt int) bool {
+	checkCanceled() // the error was most likely us killing the task, no need to ask
+
+	category := categoryOf(err)
+	log.Error("Task failed", "task", task, "category", category, "attempt", attempt, "err", err)
+
+	// Networks are flaky, give them a few tries before bothering the user
+	if category == categoryNetwork && attempt < networkRetries {
+		time.Sleep(time.Duration(attempt) * time.Second)
+		return true
+	}
+
+	// Nobody's there to answer, so don't ask
+	if unattended() {
+		fatal(err)
+	}
+
+	options := []zenity.Option{
+		zenity.Title("Venjector"),
+		zenity.ErrorIcon,
+		zenity.OKLabel("Retry"),
+		zenity.CancelLabel("Abort"),
+	}
+	if skippable(err) {
+		options = append(options, zenity.ExtraButton("Skip"))
+	}
+
+	switch zenity.Question(fmt.Sprintf("ERROR: %s - %s", task, err.Error()), options...) {
+	case nil:
+		log.Info("Retrying", "task", task)
+		return true
+	case zenity.ErrExtraButton:
+		log.Warn("Skipping", "task", task)
+		return false
+	}
+
+	fatal(err)
+	return false
+}
+
+// fatal closes the progress dialog, throws away any half-done work, tells the user what went wrong
+// and exits with the code for err's category.
+func fatal(err error) {
+	closeProgress()
+	rollback()
+
+	category := categoryOf(err)
+	if category != categoryCanceled && !unattended() { // they know, they did it
+		zenity.Error(fmt.Sprintf("ERROR: %s", err.Error()), zenity.Title("Venjector"))
+	}
+	log.Error("Aborting", "category", category, "err", err)
+	addError(err)
+	exit(category.exitCode())
+}
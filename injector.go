@@ -0,0 +1,150 @@
+/*
+	Venjector: Copyright (C) 2023 tizu69
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/charmbracelet/log"
+)
+
+// Discord's own app.asar is moved here while we're injected, Vencord's patcher loads it from there.
+const originalAsar = "_app.asar"
+
+// patcherPath is the file our loader makes Discord require.
+func patcherPath() string {
+	return profilePatcherPath(currentProfile())
+}
+
+func profilePatcherPath(name string) string {
+	return filepath.Join(profileDir(getDataPath(), name), repoDir, "dist", "patcher.js")
+}
+
+// patchAsar injects the Discord install with the resources directory resources, the same way Vencord's
+// installer does: the original app.asar is renamed to _app.asar and replaced with a loader requiring patcher.
+// Injecting an injected install just points it at patcher.
+func patchAsar(resources, patcher string) error {
+	asar := filepath.Join(resources, "app.asar")
+	original := filepath.Join(resources, originalAsar)
+
+	if _, err := os.Stat(original); errors.Is(err, os.ErrNotExist) {
+		log.Info("Moving original app.asar", "to", original)
+		if err := os.Rename(asar, original); err != nil {
+			return wrapInjectError("Failed to move app.asar", err)
+		}
+	} else if err != nil {
+		return wrapInjectError("Failed to check for "+originalAsar, err)
+	}
+
+	loader, err := loaderAsar(patcher)
+	if err != nil {
+		return wrapError(categoryUnknown, "Failed to create loader", err)
+	}
+
+	// Old installers used an app directory instead, which would shadow nothing now but confuse everyone
+	os.RemoveAll(filepath.Join(resources, "app"))
+
+	log.Info("Writing loader", "to", asar, "patcher", patcher)
+	if err := os.WriteFile(asar, loader, 0644); err != nil {
+		return wrapInjectError("Failed to write loader", err)
+	}
+	return nil
+}
+
+// unpatchAsar restores the original app.asar in resources. It's fine to unpatch a vanilla install.
+func unpatchAsar(resources string) error {
+	asar := filepath.Join(resources, "app.asar")
+	original := filepath.Join(resources, originalAsar)
+
+	if _, err := os.Stat(original); errors.Is(err, os.ErrNotExist) {
+		log.Info("Not injected, nothing to restore", "resources", resources)
+		return nil
+	}
+
+	log.Info("Restoring original app.asar", "from", original)
+	if err := os.RemoveAll(asar); err != nil {
+		return wrapInjectError("Failed to remove loader", err)
+	}
+	os.RemoveAll(filepath.Join(resources, "app"))
+
+	if err := os.Rename(original, asar); err != nil {
+		return wrapInjectError("Failed to restore app.asar", err)
+	}
+	return nil
+}
+
+func wrapInjectError(task string, err error) error {
+	// Usually a system-wide install, or Discord is still running on Windows
+	if errors.Is(err, os.ErrPermission) {
+		return wrapError(categoryPermission, task, err)
+	}
+	return wrapError(categoryFilesystem, task, err)
+}
+
+// loaderAsar builds an asar archive with a package.json and an index.js that requires patcher.
+func loaderAsar(patcher string) ([]byte, error) {
+	path, err := json.Marshal(patcher) // JSON strings are JS strings too
+	if err != nil {
+		return nil, err
+	}
+
+	files := []struct {
+		name string
+		data []byte
+	}{
+		{"index.js", []byte("require(" + string(path) + ");\n")},
+		{"package.json", []byte(`{"name":"discord","main":"index.js"}` + "\n")},
+	}
+
+	type asarFile struct {
+		Size   int    `json:"size"`
+		Offset string `json:"offset"`
+	}
+	header := struct {
+		Files map[string]asarFile `json:"files"`
+	}{map[string]asarFile{}}
+
+	body := []byte{}
+	for _, f := range files {
+		header.Files[f.name] = asarFile{Size: len(f.data), Offset: strconv.Itoa(len(body))}
+		body = append(body, f.data...)
+	}
+
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		return nil, err
+	}
+
+	// The header is a Chromium pickle holding the size of another pickle, which holds the JSON string padded to 4 bytes
+	padded := (len(headerJSON) + 3) &^ 3
+	buf := &bytes.Buffer{}
+	for _, n := range []int{4, 8 + padded, 4 + padded, len(headerJSON)} {
+		binary.Write(buf, binary.LittleEndian, uint32(n))
+	}
+	buf.Write(headerJSON)
+	buf.Write(make([]byte, padded-len(headerJSON)))
+	buf.Write(body)
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,127 @@
+/*
+	Venjector: Copyright (C) 2023 tizu69
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const dummyAsar = "Discord's own app.asar"
+
+// fakeDiscord makes a resources directory with a dummy app.asar, like a fresh Discord install.
+func fakeDiscord(t *testing.T) string {
+	t.Helper()
+
+	resources := filepath.Join(t.TempDir(), "resources")
+	if err := os.MkdirAll(resources, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(resources, "app.asar"), []byte(dummyAsar), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return resources
+}
+
+func readFile(t *testing.T, path string) []byte {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestPatchAsar(t *testing.T) {
+	resources := fakeDiscord(t)
+	patcher := filepath.Join(t.TempDir(), "cord", "dist", "patcher.js")
+
+	if err := patchAsar(resources, patcher); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := readFile(t, filepath.Join(resources, originalAsar)); string(got) != dummyAsar {
+		t.Errorf("%s is %q, want the original", originalAsar, got)
+	}
+
+	// asar doesn't compress, the loader's index.js is in there as is
+	loader := readFile(t, filepath.Join(resources, "app.asar"))
+	if !bytes.Contains(loader, []byte("require(")) || !bytes.Contains(loader, []byte(patcher)) {
+		t.Errorf("the loader doesn't require the patcher: %q", loader)
+	}
+}
+
+func TestRepatchAsar(t *testing.T) {
+	resources := fakeDiscord(t)
+	patcher := filepath.Join(t.TempDir(), "patcher.js")
+
+	if err := patchAsar(resources, patcher); err != nil {
+		t.Fatal(err)
+	}
+	loader := readFile(t, filepath.Join(resources, "app.asar"))
+
+	if err := patchAsar(resources, patcher); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, filepath.Join(resources, "app.asar")); !bytes.Equal(got, loader) {
+		t.Error("patching again changed the loader")
+	}
+	if got := readFile(t, filepath.Join(resources, originalAsar)); string(got) != dummyAsar {
+		t.Errorf("patching again lost the original, %s is %q", originalAsar, got)
+	}
+}
+
+func TestUnpatchAsar(t *testing.T) {
+	resources := fakeDiscord(t)
+
+	if err := patchAsar(resources, filepath.Join(t.TempDir(), "patcher.js")); err != nil {
+		t.Fatal(err)
+	}
+	if err := unpatchAsar(resources); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := readFile(t, filepath.Join(resources, "app.asar")); string(got) != dummyAsar {
+		t.Errorf("app.asar is %q, want the original", got)
+	}
+	if _, err := os.Stat(filepath.Join(resources, originalAsar)); !os.IsNotExist(err) {
+		t.Errorf("%s is still there", originalAsar)
+	}
+}
+
+func TestUnpatchVanillaAsar(t *testing.T) {
+	resources := fakeDiscord(t)
+
+	if err := unpatchAsar(resources); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := os.ReadDir(resources)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "app.asar" {
+		t.Errorf("resources changed: %v", entries)
+	}
+	if got := readFile(t, filepath.Join(resources, "app.asar")); string(got) != dummyAsar {
+		t.Errorf("app.asar is %q, want it untouched", got)
+	}
+}
@@ -0,0 +1,155 @@
+/*
+	Venjector: Copyright (C) 2023 tizu69
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+)
+
+// These edit a single top-level key of a JSON object in place, so everything else in the file (formatting, key
+// order, fields we don't know about) stays exactly as it was.
+
+// jsonMember is where a top-level key and its value are in a JSON document.
+type jsonMember struct {
+	key        string
+	keyStart   int
+	keyEnd     int
+	valueStart int
+	valueEnd   int
+}
+
+// jsonMembers finds the top-level members of the object in data, and where its braces are.
+func jsonMembers(data []byte) (members []jsonMember, open, close int, err error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return nil, 0, 0, errors.New("not a JSON object")
+	}
+	open = int(dec.InputOffset()) - 1
+
+	for dec.More() {
+		before := int(dec.InputOffset())
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		afterKey := int(dec.InputOffset())
+
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return nil, 0, 0, err
+		}
+
+		valueStart := afterKey + bytes.IndexByte(data[afterKey:], ':') + 1
+		for isJSONSpace(data[valueStart]) {
+			valueStart++
+		}
+		members = append(members, jsonMember{
+			key:        tok.(string),
+			keyStart:   before + bytes.IndexByte(data[before:], '"'),
+			keyEnd:     afterKey,
+			valueStart: valueStart,
+			valueEnd:   int(dec.InputOffset()),
+		})
+	}
+
+	if _, err := dec.Token(); err != nil {
+		return nil, 0, 0, err
+	}
+	close = int(dec.InputOffset()) - 1
+	return members, open, close, nil
+}
+
+func isJSONSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+// setJSONKey sets key to value, replacing only the old value if there is one, or adding it after the last key,
+// formatted like the ones before it.
+func setJSONKey(data []byte, key string, value interface{}) ([]byte, error) {
+	members, open, close, err := jsonMembers(data)
+	if err != nil {
+		return nil, err
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	// Duplicate keys are fine in JSON, the last one wins
+	for i := len(members) - 1; i >= 0; i-- {
+		if m := members[i]; m.key == key {
+			return splice(data, m.valueStart, m.valueEnd, encoded), nil
+		}
+	}
+
+	encodedKey, _ := json.Marshal(key)
+	if len(members) == 0 {
+		return splice(data, open+1, close, append(append(encodedKey, ": "...), encoded...)), nil
+	}
+
+	first, last := members[0], members[len(members)-1]
+	colon := data[first.keyEnd:first.valueStart]
+
+	// Separated like the last two keys, or indented like the only one
+	member := append([]byte{','}, data[open+1:first.keyStart]...)
+	if len(members) > 1 {
+		member = append([]byte{}, data[members[len(members)-2].valueEnd:last.keyStart]...)
+	} else if len(member) == 1 {
+		member = append(member, ' ')
+	}
+	member = append(append(append(member, encodedKey...), colon...), encoded...)
+	return splice(data, last.valueEnd, last.valueEnd, member), nil
+}
+
+// deleteJSONKey removes every key, with its value and the comma that went with it.
+func deleteJSONKey(data []byte, key string) ([]byte, error) {
+	for {
+		members, open, close, err := jsonMembers(data)
+		if err != nil {
+			return nil, err
+		}
+
+		i := -1
+		for j, m := range members {
+			if m.key == key {
+				i = j
+				break
+			}
+		}
+
+		switch {
+		case i == -1:
+			return data, nil
+		case len(members) == 1:
+			data = splice(data, open+1, close, nil)
+		case i == 0:
+			data = splice(data, members[0].keyStart, members[1].keyStart, nil)
+		default:
+			data = splice(data, members[i-1].valueEnd, members[i].valueEnd, nil)
+		}
+	}
+}
+
+func splice(data []byte, start, end int, with []byte) []byte {
+	out := make([]byte, 0, len(data)-(end-start)+len(with))
+	out = append(out, data[:start]...)
+	out = append(out, with...)
+	return append(out, data[end:]...)
+}
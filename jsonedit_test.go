@@ -0,0 +1,90 @@
+/*
+	Venjector: Copyright (C) 2023 tizu69
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import "testing"
+
+func TestSetJSONKey(t *testing.T) {
+	for _, c := range []struct {
+		name, data string
+		value      interface{}
+		want       string
+	}{
+		{"replaces", "{\n  \"vencordDir\": \"/old\",\n  \"minimizeToTray\": true\n}\n", "/new",
+			"{\n  \"vencordDir\": \"/new\",\n  \"minimizeToTray\": true\n}\n"},
+		{"adds after the last key", "{\n  \"minimizeToTray\": true\n}\n", "/new",
+			"{\n  \"minimizeToTray\": true,\n  \"vencordDir\": \"/new\"\n}\n"},
+		{"empty object", "{}", "/new", "{\"vencordDir\": \"/new\"}"},
+		{"one line", `{"minimizeToTray": true}`, "/new", `{"minimizeToTray": true, "vencordDir": "/new"}`},
+		{"spaced like the others", "{\"a\":1 , \"b\":2}", "/new", "{\"a\":1 , \"b\":2 , \"vencordDir\":\"/new\"}"},
+		{"only replaces the last of duplicates", `{"vencordDir": "/a", "vencordDir": "/b"}`, "/new",
+			`{"vencordDir": "/a", "vencordDir": "/new"}`},
+		{"leaves nested keys alone", "{\n  \"arRPC\": { \"vencordDir\": \"/nested\" }\n}", "/new",
+			"{\n  \"arRPC\": { \"vencordDir\": \"/nested\" },\n  \"vencordDir\": \"/new\"\n}"},
+		{"replaces an object value", `{"vencordDir": {"a": [1, {"b": 2}]}, "x": 1}`, "/new",
+			`{"vencordDir": "/new", "x": 1}`},
+		{"CRLF and tabs", "{\r\n\t\"minimizeToTray\":\ttrue\r\n}\r\n", "/new",
+			"{\r\n\t\"minimizeToTray\":\ttrue,\r\n\t\"vencordDir\":\t\"/new\"\r\n}\r\n"},
+		{"braces and quotes in strings", `{"{\"}\":": "}{,", "x": "a\\"}`, `C:\{"}`,
+			`{"{\"}\":": "}{,", "x": "a\\", "vencordDir": "C:\\{\"}"}`},
+	} {
+		got, err := setJSONKey([]byte(c.data), "vencordDir", c.value)
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+		} else if string(got) != c.want {
+			t.Errorf("%s: got %q, want %q", c.name, got, c.want)
+		}
+	}
+}
+
+func TestDeleteJSONKey(t *testing.T) {
+	for _, c := range []struct {
+		name, data, want string
+	}{
+		{"first key", "{\n  \"vencordDir\": \"/a\",\n  \"minimizeToTray\": true\n}\n",
+			"{\n  \"minimizeToTray\": true\n}\n"},
+		{"middle key", `{"a": 1, "vencordDir": "/a", "b": 2}`, `{"a": 1, "b": 2}`},
+		{"last key", "{\n  \"minimizeToTray\": true,\n  \"vencordDir\": \"/a\"\n}\n",
+			"{\n  \"minimizeToTray\": true\n}\n"},
+		{"only key", "{\n  \"vencordDir\": \"/a\"\n}\n", "{}\n"},
+		{"every duplicate", `{"vencordDir": "/a", "x": 1, "vencordDir": "/b"}`, `{"x": 1}`},
+		{"missing", `{"x": 1}`, `{"x": 1}`},
+		{"leaves nested keys alone", `{"arRPC": {"vencordDir": "/nested"}}`, `{"arRPC": {"vencordDir": "/nested"}}`},
+		{"CRLF and tabs", "{\r\n\t\"x\": 1,\r\n\t\"vencordDir\": \"/a\"\r\n}\r\n", "{\r\n\t\"x\": 1\r\n}\r\n"},
+		{"braces and quotes in strings", `{"vencordDir": "}\",{", "x": "{\"vencordDir\": 1}"}`,
+			`{"x": "{\"vencordDir\": 1}"}`},
+	} {
+		got, err := deleteJSONKey([]byte(c.data), "vencordDir")
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+		} else if string(got) != c.want {
+			t.Errorf("%s: got %q, want %q", c.name, got, c.want)
+		}
+	}
+}
+
+func TestJSONEditNotAnObject(t *testing.T) {
+	for _, data := range []string{"", "null", "[]", `"vencordDir"`, `{"vencordDir": `} {
+		if _, err := setJSONKey([]byte(data), "vencordDir", "/new"); err == nil {
+			t.Errorf("set in %q didn't fail", data)
+		}
+		if _, err := deleteJSONKey([]byte(data), "vencordDir"); err == nil {
+			t.Errorf("delete in %q didn't fail", data)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+/*
+	Venjector: Copyright (C) 2023 tizu69
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+// The lock file keeps two Venjectors from using the same data directory at once, like two reloads started from
+// the client both deleting cord. It holds the PID of its owner, so the lock of a crashed run can be told apart.
+const lockFile = "venjector.lock"
+
+// heldLock is the lock file we own, if any.
+var heldLock string
+
+// lockDataDir takes the lock on the data directory. If another run has it, it fails, or waits for it with --wait.
+func lockDataDir() error {
+	dir := getDataPath()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return wrapError(categoryFilesystem, "Failed to create "+dir, err)
+	}
+
+	path := filepath.Join(dir, lockFile)
+	for waiting := false; ; {
+		err := tryLock(path)
+		if !errors.Is(err, os.ErrExist) {
+			return err
+		}
+
+		pid, live, err := lockOwner(path)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			continue // let go of in the meantime, try again
+		case err != nil:
+			return wrapError(categoryFilesystem, "Failed to read "+path, err)
+		case !live:
+			log.Warn("Removing lock of a run that's gone", "lock", path, "pid", pid)
+			if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return wrapError(categoryFilesystem, "Failed to remove stale lock "+path, err)
+			}
+			continue
+		case !cli.Wait:
+			return wrapError(categoryBusy, "Another Venjector run is in progress",
+				fmt.Errorf("PID %d is using %s, try again when it's done", pid, dir))
+		case !waiting:
+			log.Info("Waiting for another Venjector run to finish", "pid", pid)
+			waiting = true
+		}
+
+		select {
+		case <-runCtx.Done():
+			return wrapError(categoryCanceled, "Canceled while waiting for another run", runCtx.Err())
+		case <-time.After(lockPollInterval):
+		}
+	}
+}
+
+const lockPollInterval = time.Second
+
+// tryLock creates the lock file at path, failing with os.ErrExist if someone has it.
+func tryLock(path string) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if errors.Is(err, os.ErrExist) {
+		return err
+	} else if err != nil {
+		return wrapError(categoryFilesystem, "Failed to create "+path, err)
+	}
+	defer f.Close()
+
+	if _, err := fmt.Fprintln(f, os.Getpid()); err != nil {
+		os.Remove(path)
+		return wrapError(categoryFilesystem, "Failed to write "+path, err)
+	}
+
+	log.Info("Locked data directory", "lock", path)
+	heldLock = path
+	return nil
+}
+
+// lockOwner reads the PID from the lock file at path, and whether it's still running. If the lock is gone by now,
+// it fails with os.ErrNotExist.
+func lockOwner(path string) (int, bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, false, err
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		// Either it's being written right now, or it's garbage
+		info, err := os.Stat(path)
+		if err != nil {
+			return 0, false, err
+		}
+		return 0, time.Since(info.ModTime()) < 10*time.Second, nil
+	}
+	return pid, pid != os.Getpid() && processAlive(pid), nil
+}
+
+// unlockDataDir gives the lock back, if we have it.
+func unlockDataDir() {
+	if heldLock == "" {
+		return
+	}
+	if err := os.Remove(heldLock); err != nil {
+		log.Warn("Failed to remove lock", "lock", heldLock, "err", err)
+	}
+	heldLock = ""
+}
@@ -0,0 +1,235 @@
+/*
+	Venjector: Copyright (C) 2023 tizu69
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+const logTimeFormat = "2006-01-02_15-04-05"
+
+var (
+	// Everything logged before the run log exists ends up in here, and is flushed into it later
+	earlyLog   = new(bytes.Buffer)
+	runLogDir  = ""
+	runLogNext = 1
+)
+
+func getLogsPath() string {
+	return filepath.Join(getDataPath(), "logs")
+}
+
+// startRunLog creates this run's log directory, moves our own log into it and prunes old runs.
+func startRunLog() error {
+	if err := os.MkdirAll(getLogsPath(), 0755); err != nil {
+		return wrapError(categoryFilesystem, "Failed to create log directory", err)
+	}
+
+	// The PID keeps runs started in the same second apart, like one that --wait'ed for the other
+	dir := filepath.Join(getLogsPath(), fmt.Sprintf("%s_%d", startTime.Format(logTimeFormat), os.Getpid()))
+	if err := os.Mkdir(dir, 0755); err != nil {
+		return wrapError(categoryFilesystem, "Failed to create log directory", err)
+	}
+
+	f, err := createLogFile(filepath.Join(dir, "venjector.log"))
+	if err != nil {
+		return wrapError(categoryFilesystem, "Failed to create log file", err)
+	}
+	f.Write(earlyLog.Bytes())
+	earlyLog.Reset()
+
+	runLogDir = dir
+	log.SetOutput(io.MultiWriter(os.Stderr, f))
+	log.Info("Logging to", "dir", dir)
+
+	pruneLogs()
+	return nil
+}
+
+// createLogFile creates a new log file, it never overwrites another run's.
+func createLogFile(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+}
+
+// pruneLogs removes all but the newest --log-retention runs.
+func pruneLogs() {
+	keep := max(cli.LogRetention, 1) // never remove the current run
+
+	runs, err := listLogRuns()
+	if err != nil || len(runs) <= keep {
+		return
+	}
+
+	for _, run := range runs[:len(runs)-keep] {
+		log.Info("Removing old log", "run", run)
+		os.RemoveAll(filepath.Join(getLogsPath(), run))
+	}
+}
+
+// listLogRuns returns the names of all log directories, oldest first.
+func listLogRuns() ([]string, error) {
+	entries, err := os.ReadDir(getLogsPath())
+	if err != nil {
+		return nil, err
+	}
+
+	runs := []string{}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			runs = append(runs, entry.Name())
+		}
+	}
+	sort.Strings(runs) // the timestamp format sorts nicely
+
+	return runs, nil
+}
+
+// lastLogDir returns the newest log directory. The current run is skipped if it didn't run anything yet,
+// as that's probably not what you're looking for.
+func lastLogDir() (string, error) {
+	runs, err := listLogRuns()
+	if err != nil {
+		return "", wrapError(categoryFilesystem, "Failed to list logs", err)
+	}
+
+	for i := len(runs) - 1; i >= 0; i-- {
+		dir := filepath.Join(getLogsPath(), runs[i])
+		if dir == runLogDir && runLogNext == 1 && i > 0 {
+			continue
+		}
+		return dir, nil
+	}
+	return "", wrapError(categoryFilesystem, "Failed to find logs", os.ErrNotExist)
+}
+
+// runLogged runs command, writing its stdout and stderr into this run's log directory while streaming them
+// (see newStreamer). If it fails, the last few lines of stderr are added to the error.
+func runLogged(name string, command *exec.Cmd, parse lineProgress) error {
+	stream := newStreamer(name, parse)
+	stdoutLines := &lineWriter{onLine: stream}
+	stderrLines := &lineWriter{onLine: stream}
+	defer stdoutLines.Flush()
+	defer stderrLines.Flush()
+
+	stderr := new(bytes.Buffer)
+	command.Stdout = stdoutLines
+	command.Stderr = io.MultiWriter(stderr, stderrLines)
+
+	if runLogDir != "" {
+		prefix := filepath.Join(runLogDir, fmt.Sprintf("%02d-%s", runLogNext, name))
+		runLogNext++
+
+		stdoutFile, err := createLogFile(prefix + ".stdout.log")
+		if err != nil {
+			return wrapError(categoryFilesystem, "Failed to create log file", err)
+		}
+		defer stdoutFile.Close()
+
+		stderrFile, err := createLogFile(prefix + ".stderr.log")
+		if err != nil {
+			return wrapError(categoryFilesystem, "Failed to create log file", err)
+		}
+		defer stderrFile.Close()
+
+		fmt.Fprintf(stdoutFile, "$ %s\n", strings.Join(command.Args, " "))
+		command.Stdout = io.MultiWriter(stdoutFile, stdoutLines)
+		command.Stderr = io.MultiWriter(stderr, stderrFile, stderrLines)
+	}
+
+	start := time.Now()
+	err := command.Run()
+	log.Info("Ran "+name, "took", time.Since(start).Round(time.Millisecond), "logs", runLogDir)
+
+	if err != nil {
+		return fmt.Errorf("%w\n%s", err, lastLines(stderr.String(), 10))
+	}
+	return nil
+}
+
+func lastLines(s string, n int) string {
+	lines := strings.Split(strings.TrimRight(s, "\n"), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// showLogs is the 'venjector logs' command.
+func showLogs() error {
+	if cli.Logs.List {
+		runs, err := listLogRuns()
+		if err != nil {
+			return wrapError(categoryFilesystem, "Failed to list logs", err)
+		}
+		for _, run := range runs {
+			fmt.Println(run)
+		}
+		return nil
+	}
+
+	dir, err := logRunDir(cli.Logs.Run)
+	if err != nil {
+		return err
+	}
+
+	if cli.Logs.Open {
+		return openByPath(dir)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return wrapError(categoryFilesystem, "Failed to read logs", err)
+	}
+
+	for _, entry := range entries {
+		contents, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return wrapError(categoryFilesystem, "Failed to read logs", err)
+		}
+		fmt.Printf("==> %s <==\n%s\n", filepath.Join(dir, entry.Name()), contents)
+	}
+	return nil
+}
+
+// logRunDir is the log directory of run, which has to be one listLogRuns knows, or the last one if run is "".
+func logRunDir(run string) (string, error) {
+	if run == "" {
+		return lastLogDir()
+	}
+
+	runs, err := listLogRuns()
+	if err != nil {
+		return "", wrapError(categoryFilesystem, "Failed to list logs", err)
+	}
+	if !slices.Contains(runs, run) {
+		return "", wrapError(categoryPrerequisite, "No such run, see 'venjector logs --list'", errors.New(run))
+	}
+	return filepath.Join(getLogsPath(), run), nil
+}
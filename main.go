@@ -20,10 +20,12 @@ package main
 import (
 	"embed"
 	"encoding/json"
-	"net/http"
+	"errors"
+	"fmt"
+	"io"
 	"os"
 	"path/filepath"
-	"runtime"
+	"sync/atomic"
 	"time"
 
 	"github.com/alecthomas/kong"
@@ -36,45 +38,163 @@ import (
 var core embed.FS
 
 var cli struct {
-	LocalData  bool `help:"Do not use app data directory" default:"false"`
-	AutoChoice int  `help:"Which user choice to make" default:"-1"`
-	Visual     bool `help:"Visualize the progress" default:"false"`
-	Tipless    bool `help:"No tips" default:"false"`
+	LocalData    bool   `help:"Keep everything in ./venjectorConfig instead of the app data directories" default:"false"`
+	DataDir      string `help:"Keep everything in this directory instead of the app data directories" type:"path" env:"VENJECTOR_HOME"`
+	AutoChoice   int    `help:"Which user choice to make" default:"-1"`
+	Visual       bool   `help:"Visualize the progress" default:"false"`
+	Tipless      bool   `help:"No tips" default:"false"`
+	JSON         bool   `help:"Print a machine-readable result to stdout when exiting" default:"false" name:"json"`
+	LogRetention int    `help:"How many runs to keep logs for" default:"10"`
+	Wait         bool   `help:"If another run is in progress, wait for it instead of failing" env:"VENJECTOR_WAIT"`
+	Events       bool   `help:"Print progress events to stdout before the result, for the daemon" hidden:""`
+
+	ConfigHome string `help:"Where overrides and settings are kept" type:"path" env:"VENJECTOR_CONFIG_HOME"`
+	DataHome   string `help:"Where the Vencord build, toolchain and logs are kept" type:"path" env:"VENJECTOR_DATA_HOME"`
+	CacheHome  string `help:"Where downloads that can be redone are kept" type:"path" env:"VENJECTOR_CACHE_HOME"`
+	Profile    string `help:"Which profile to use, see 'venjector profile'" default:"default" env:"VENJECTOR_PROFILE"`
+
+	ManagedToolchain bool   `help:"Use a private Node.js and pnpm instead of the ones in PATH" env:"VENJECTOR_MANAGED_TOOLCHAIN"`
+	NodeMirror       string `help:"Where the managed toolchain downloads Node.js from" default:"https://nodejs.org/dist" env:"VENJECTOR_NODE_MIRROR"`
+	NodeVersion      string `help:"Node.js version for the managed toolchain, defaults to the newest LTS Vencord supports"`
+	NodeTarball      string `help:"Local Node.js archive for the managed toolchain, verified against --node-sha256 or a SHASUMS256.txt next to it" type:"path"`
+	NodeSha256       string `help:"SHA-256 of --node-tarball, in hex"`
+	NpmRegistry      string `help:"Where the managed toolchain downloads pnpm from" default:"https://registry.npmjs.org" env:"VENJECTOR_NPM_REGISTRY"`
+	PnpmTarball      string `help:"Local pnpm archive for the managed toolchain, needs --pnpm-integrity" type:"path"`
+	PnpmIntegrity    string `help:"Integrity of --pnpm-tarball, like the npm registry's dist.integrity (sha512-...)"`
+
+	Runner      string        `help:"How to run pnpm: auto, system, corepack, managed or dry-run" default:"auto" enum:"auto,system,corepack,managed,dry-run" env:"VENJECTOR_RUNNER"`
+	StepTimeout time.Duration `help:"Give up on a pnpm command after this long, 0 to wait forever" default:"30m"`
+
+	Offline    bool   `help:"Rebuild without network, from the last build, pnpm's store and cached plugins" env:"VENJECTOR_OFFLINE"`
+	RepoBundle string `help:"With --offline, clone Vencord from this git bundle instead of the last build" type:"path"`
+
+	Run    struct{} `cmd:"" default:"1" help:"Open Venjector (default)"`
+	Doctor struct{} `cmd:"" help:"Check that everything Venjector needs is installed"`
+	Status struct{} `cmd:"" help:"Show which clients Venjector is injected into"`
+	Logs   struct {
+		Run  string `arg:"" optional:"" help:"Which run to show, defaults to the last one"`
+		List bool   `help:"List all runs instead"`
+		Open bool   `help:"Open the log directory instead of printing it"`
+	} `cmd:"" help:"Show the logs of previous runs"`
+	Export struct {
+		Output string `arg:"" optional:"" default:"venjector-bundle.tar.gz" help:"Where to write the bundle" type:"path"`
+	} `cmd:"" help:"Pack your build setup into a bundle for other machines"`
+	Inject struct {
+		Location string `arg:"" optional:"" help:"Discord install or Vesktop config directory, defaults to every client found" type:"path"`
+	} `cmd:"" help:"Inject Venjector into Discord without the GUI"`
+	Uninject struct {
+		Location string `arg:"" optional:"" help:"Discord install or Vesktop config directory, defaults to every client found" type:"path"`
+	} `cmd:"" help:"Restore the original Discord"`
+	Profiles struct {
+		List   struct{} `cmd:"" default:"1" help:"List profiles (default)"`
+		Create struct {
+			Name   string `arg:"" help:"Name of the new profile"`
+			From   string `help:"Profile to copy overrides and settings from"`
+			Ref    string `help:"Vencord branch, tag or commit to build"`
+			Client string `help:"Discord install or Vesktop config directory to inject after reloading" type:"path"`
+		} `cmd:"" help:"Create a profile"`
+		Clone struct {
+			From string `arg:"" help:"Profile to copy"`
+			Name string `arg:"" help:"Name of the copy"`
+		} `cmd:"" help:"Copy a profile's overrides and settings into a new one"`
+		Set struct {
+			Name   string `arg:"" help:"Profile to change"`
+			Ref    string `help:"Vencord branch, tag or commit to build, '-' for the default branch"`
+			Client string `help:"Discord install or Vesktop config directory to inject after reloading, '-' for none"`
+		} `cmd:"" help:"Change a profile's settings"`
+		Delete struct {
+			Name string `arg:"" help:"Profile to delete"`
+		} `cmd:"" help:"Delete a profile with its plugins and build"`
+	} `cmd:"" name:"profile" help:"Manage profiles, separate plugin sets with their own build"`
+	Watch     struct{} `cmd:"" help:"Rebuild whenever something in overrides changes, for working on plugins"`
+	NewPlugin struct {
+		Name        string `arg:"" help:"Name of the plugin, like MyPlugin"`
+		Description string `help:"What the plugin does"`
+		Author      string `help:"Who made it, defaults to git's user.name"`
+		Settings    bool   `help:"Add settings"`
+		Patches     bool   `help:"Add a patch to Discord's code"`
+		Native      bool   `help:"Add a native module, for Node.js APIs"`
+	} `cmd:"" help:"Create a plugin skeleton in overrides/src/userplugins"`
+	Daemon struct {
+		Listen string `help:"Listen on this loopback address, like 127.0.0.1:7531, instead of a Unix socket"`
+	} `cmd:"" help:"Serve a local API for the in-client plugin, see README.md"`
+	Import struct {
+		Bundle string `arg:"" help:"The bundle to import" type:"existingfile"`
+	} `cmd:"" help:"Restore a bundle made with 'export' and rebuild from it, offline"`
 }
 var progress zenity.ProgressDialog
+var progressClosed *atomic.Bool
 var process = 0
 
 func main() {
 	log.SetReportCaller(true)
+	log.SetOutput(io.MultiWriter(os.Stderr, earlyLog))
 
-	kong.Parse(&cli)
+	ctx := kong.Parse(&cli)
+
+	if err := prepareHomePath(); err != nil {
+		log.Error("Can't use the data directory", "err", err)
+		os.Exit(categoryOf(err).exitCode())
+	}
+	if err := checkProfileName(currentProfile()); err != nil || !profileExists(currentProfile()) {
+		log.Error("No such profile, create it with 'venjector profile create'", "profile", currentProfile())
+		os.Exit(categoryPrerequisite.exitCode())
+	}
+
+	switch command := ctx.Command(); command {
+	case "logs", "logs <run>":
+		runSubcommand(showLogs)
+	case "doctor":
+		runSubcommand(doctor)
+	case "status":
+		runSubcommand(statusCommand)
+	case "export", "export <output>":
+		runSubcommand(locked(exportBundle))
+	case "inject", "inject <location>":
+		runSubcommand(locked(injectCommand))
+	case "uninject", "uninject <location>":
+		runSubcommand(locked(uninjectCommand))
+	case "profile", "profile list":
+		runSubcommand(func() error { return profileCommand(command) })
+	case "profile create <name>", "profile set <name>", "profile delete <name>":
+		runSubcommand(locked(func() error { return profileCommand(command) }))
+	case "new-plugin <name>":
+		runSubcommand(locked(newPluginCommand))
+	case "watch":
+		watchSignals()
+		runSubcommand(locked(watchCommand))
+	case "daemon":
+		watchSignals()
+		runSubcommand(runDaemon)
+	case "profile clone <from> <name>":
+		runSubcommand(locked(func() error { return createProfile(cli.Profiles.Clone.Name, cli.Profiles.Clone.From) }))
+	}
 
 	log.Info("Welcome to Venjector!")
+	watchSignals()
+	if err := lockAndMigrate(); err != nil {
+		fatal(err)
+	}
 
 	err := clipboard.Init()
-	fatalIfError("Failed to initialize clipboard", err)
+	if err != nil {
+		fatal(wrapError(categoryPrerequisite, "Failed to initialize clipboard", err))
+	}
 
-	newProgress(2)
-	setVal(1, "Looking for PNPM", ensurePnpm)
-	setVal(2, "Looking for Git", ensureGit)
+	newProgress(1)
+	setVal(1, "Checking prerequisites", ensurePrerequisites)
 
 	progress.Text("Welcome to Venjector!")
 	time.Sleep(1 * time.Second) // This delay is unnecessary, but here to make the message readable
 
-	if cli.LocalData {
-		d, err := zenity.Entry("Please enter your local data directory", zenity.EntryText(getConfigPath()))
-		fatalIfError("Failed to get local data directory", err)
-
-		switch runtime.GOOS {
-		case "linux":
-			configLnxLocal = d
-		case "darwin":
-			configMacLocal = d
-		case "windows":
-			configWinLocal = d
-		default:
-			log.Fatal("Unsupported OS")
-		}
+	if err := startRunLog(); err != nil {
+		fatal(err)
+	}
+
+	if ctx.Command() == "import <bundle>" {
+		newProgress(1)
+		setVal(1, "Importing bundle", importBundle)
+		cli.AutoChoice = 0 // and rebuild from it
 	}
 
 	for i := 0; true; i++ {
@@ -83,29 +203,41 @@ func main() {
 		}
 
 		userChoice()
+		result.Choice = process
 
 		switch process {
 		case 0: // rebuild
-			newProgress(8)
-			setVal(1, "Downloading Vencord", pullRepo)
-			setVal(2, "Installing dependencies", pnpmInstall)
-			setVal(3, "Copying plugins", copyOverrides)
-			setVal(3, "Downloading remote plugins", downloadPlugs)
-			setVal(4, "Copying VenjectorCore", copyCore)
-			setVal(5, "Changing reload-time variables", reloadVars)
-			setVal(6, "Running tests", pnpmTest)
-			setVal(7, "Building Vencord with plugins", pnpmBuild)
-			setVal(8, "Adapting Vencord", replaceDev)
+			newProgress(11)
+			setVal(1, "Checking what's available offline", checkOffline)
+			setVal(1, taskPull, pullRepo)
+			setVal(2, "Preparing toolchain", provisionToolchain)
+			setVal(3, taskInstall, pnpmInstall)
+			setVal(4, "Copying plugins", copyOverrides)
+			setVal(4, "Downloading remote plugins", downloadPlugs)
+			setVal(5, "Copying VenjectorCore", copyCore)
+			setVal(6, "Changing reload-time variables", reloadVars)
+			setVal(7, "Running tests", pnpmTest)
+			setVal(8, taskBuild, pnpmBuild)
+			setVal(9, "Replacing the previous build", unlessDryRun(commitRepo))
+			setVal(10, "Updating Vesktop Flatpak copies", unlessDryRun(refreshVesktopCopies))
+			setVal(11, "Injecting the profile's client", unlessDryRun(injectProfileClient))
+			if isDryRun() {
+				addWarning(fmt.Sprintf("Dry run, %d pnpm commands weren't run and the previous build was kept",
+					len(dryRunner.Tasks)))
+			}
 
+			result.Commit = builtCommit()
 			extras := ""
 
-			userpluginLocation := filepath.Join(getConfigPath(), "overrides", "src", "userplugins")
+			userpluginLocation := filepath.Join(getProfilePath(), "overrides", "src", "userplugins")
 			if e, err := isEmpty(userpluginLocation); err != nil || e {
+				addWarning("Plugin directory was empty, so no custom plugins were injected.")
 				extras += "\n\nWARN: Plugin directory was empty, so no custom plugins were injected."
 			}
 
-			pluginLocation := filepath.Join(getConfigPath(), "overrides", "src", "plugins")
+			pluginLocation := filepath.Join(getProfilePath(), "overrides", "src", "plugins")
 			if e, err := isEmpty(pluginLocation); !os.IsNotExist(err) && !e {
+				addWarning("Explicit plugin override, prefer using 'userplugins' directory for custom plugins.")
 				extras += "\n\nWARN: Explicit plugin override, prefer using 'userplugins' directory for custom plugins."
 			}
 
@@ -118,63 +250,86 @@ func main() {
 			}
 
 		case 2: // inject
-			newProgress(1)
-
-			if !cli.Tipless {
-				err := zenity.Question("You're about to install or uninstall Venjector. Only use this if:\n" +
-					"- You reloaded plugins at least once\n" +
-					"- You don't have the Venjector patch installed\n" +
-					"- You got it installed, but want to uninstall it\n" +
-					"- You're using the vanilla client (see 'Install Vesktop' for Vesktop info)")
-				if err != nil {
-					progress.Close()
-					continue
-				}
-			}
-
-			setVal(1, "Injecting Discord with Venjector", injecc)
-			zenity.Info("All done! Restart (not just hide!) your client to apply the changes.")
+			manageClients()
 		case 4: // vesktop guide
+			vesktop, err := pickVesktop()
+			if errors.Is(err, zenity.ErrCanceled) {
+				continue
+			} else if err != nil {
+				zenity.Error(err.Error(), zenity.Title("Venjector"))
+				continue
+			}
 			newProgress(1)
 
+			action := "Install"
 			if !cli.Tipless {
-				err := zenity.Question("You're about to install Venjector for Vesktop. Only use this if:\n"+
+				action, err = zenity.List("Venjector for Vesktop. Only use this if:\n"+
 					"- You reloaded plugins at least once\n"+
 					"- You are using the Vesktop client!!\n"+
-					"- You don't have the Vesktop patch installed\n"+
 					"- VESKTOP CURRENTLY ISN'T RUNNING\n\n"+
+					"Vesktop is currently "+getStatus(vesktop).State.String()+".\n\n"+
 					"To manually install Venjector, open Vesktop -> Settings -> Vesktop Settings -> Vencord Location and change"+
-					" to the copied location (click 'Copy location')\n\n"+
-					"To uninstall Venjector, open Vesktop -> Settings -> Vesktop Settings -> Vencord Location -> Reset",
-					zenity.ExtraButton("Copy location"), zenity.OKLabel("Auto-install"))
-				if err == zenity.ErrExtraButton {
-					setVal(1, "Copying Vesktop path", func() {
-						path, err := filepath.Abs(getConfigPath())
-						fatalIfError("Failed to get Vesktop path", err)
-						clipboard.Write(clipboard.FmtText, []byte(filepath.Join(path, "cord", "dist")))
-						time.Sleep(1 * time.Second)
-					})
-					continue
-				} else if err != nil {
-					progress.Close()
+					" to the copied location (pick 'Copy location')",
+					[]string{"Install", "Uninstall", "Copy location"},
+					zenity.Title("Venjector"), zenity.DisallowEmpty())
+				if err != nil {
+					closeProgress()
 					continue
 				}
 			}
 
-			setVal(1, "Injecting Vesktop with Venjector", injeccVesktop)
+			switch action {
+			case "Copy location":
+				setVal(1, "Copying Vesktop path", func() error {
+					if vesktop.Sandboxed {
+						if err := copyBuildForSandbox(vesktop); err != nil {
+							return err
+						}
+					}
+					path, err := filepath.Abs(vesktopBuildDir(vesktop))
+					if err != nil {
+						return wrapError(categoryFilesystem, "Failed to get Vesktop path", err)
+					}
+					clipboard.Write(clipboard.FmtText, []byte(path))
+					time.Sleep(1 * time.Second)
+					return nil
+				})
+				continue
+			case "Uninstall":
+				setVal(1, "Restoring Vesktop's own Vencord", uninjeccVesktop)
+			default:
+				setVal(1, "Injecting Vesktop with Venjector", injeccVesktop)
+			}
 			zenity.Info("All done! Restart your client to apply the changes.")
+		case 6: // profiles
+			manageProfiles()
+		case 7: // new plugin
+			if err := newPluginDialog(); err != nil {
+				zenity.Error(err.Error(), zenity.Title("Venjector"))
+			}
+		case 5: // last log
+			newProgress(1)
+			setVal(1, "Opening last log", func() error {
+				dir, err := lastLogDir()
+				if err != nil {
+					return err
+				}
+				return openByPath(dir)
+			})
 		case 1: // local plugins
 			newProgress(1)
-			setVal(1, "Opening plugin directory", func() {
-				openByPath(filepath.Join(getConfigPath(), "overrides", "src", "userplugins"))
+			setVal(1, "Opening plugin directory", func() error {
+				return openByPath(filepath.Join(getProfilePath(), "overrides", "src", "userplugins"))
 			})
 		case 3: // remote plugins
-			f, err := os.OpenFile(filepath.Join(getConfigPath(), "remote.json"), os.O_CREATE|os.O_RDONLY, 0644)
-			fatalIfError("Failed to open remote.json", err)
+			f, err := os.OpenFile(filepath.Join(getProfilePath(), "remote.json"), os.O_CREATE|os.O_RDONLY, 0644)
+			if err != nil {
+				fatal(wrapError(categoryFilesystem, "Failed to open remote.json", err))
+			}
 
 			var data []string = []string{}
 			json.NewDecoder(f).Decode(&data)
-			// fatalIfError("Failed to decode remote.json", err)
+			f.Close()
 
 			for {
 				for i, v := range data {
@@ -210,9 +365,18 @@ func main() {
 					continue
 				}
 
+				if cli.Offline {
+					zenity.Warning("You're offline, so the plugin URL can't be checked. Offline reloads will fail until it was downloaded by an online one.")
+					data = append(data, inp)
+					continue
+				}
+
 				// check if url is valid
-				b, err := http.Get(inp)
-				if err != nil || b.StatusCode != 200 {
+				b, err := httpGet(inp)
+				if err != nil {
+					zenity.Error("Invalid plugin URL")
+					continue
+				} else if b.StatusCode != 200 {
 					zenity.Error("Invalid plugin URL")
 					b.Body.Close()
 					continue
@@ -222,11 +386,41 @@ func main() {
 				data = append(data, inp)
 			}
 
-			result, err := json.Marshal(data)
-			fatalIfError("Failed to marshal remote.json", err)
+			if err := writeRemoteList(data); err != nil {
+				fatal(err)
+			}
+		}
+	}
+	exit(exitOK)
+}
+
+// runSubcommand runs a command that doesn't need the GUI, and exits.
+func runSubcommand(run func() error) {
+	log.SetOutput(os.Stderr)
+	err := run()
+	unlockDataDir()
+	if err != nil {
+		log.Error("Failed", "err", err)
+		os.Exit(categoryOf(err).exitCode())
+	}
+	os.Exit(exitOK)
+}
 
-			err = os.WriteFile(filepath.Join(getConfigPath(), "remote.json"), result, 0644)
-			fatalIfError("Failed to write remote.json", err)
+// locked is run, with the data directory locked while it runs.
+func locked(run func() error) func() error {
+	return func() error {
+		if err := lockAndMigrate(); err != nil {
+			return err
 		}
+		return run()
+	}
+}
+
+// lockAndMigrate locks the data directory, then moves an older layout to where things go now. Not the other way
+// around, or two runs started at once would both be moving the same things.
+func lockAndMigrate() error {
+	if err := lockDataDir(); err != nil {
+		return err
 	}
+	return migrateLayout()
 }
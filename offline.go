@@ -0,0 +1,80 @@
+/*
+	Venjector: Copyright (C) 2023 tizu69
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/charmbracelet/log"
+)
+
+// remoteCachePath is where the last download of a remote plugin is kept, for --offline.
+func remoteCachePath(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return filepath.Join(getCachePath(), "remote", hex.EncodeToString(sum[:]), "index.tsx")
+}
+
+// offlineRepoSource is what pullRepo clones from with --offline: the bundle, if given, or the last build.
+func offlineRepoSource() string {
+	if cli.RepoBundle != "" {
+		return cli.RepoBundle
+	}
+	return filepath.Join(getBuildPath(), repoDir)
+}
+
+// checkOffline makes sure everything a rebuild needs is here before we start one with --offline,
+// instead of failing halfway through. It reports every problem at once.
+func checkOffline() error {
+	if !cli.Offline {
+		return nil
+	}
+
+	problems := []string{}
+
+	if _, err := os.Stat(offlineRepoSource()); err != nil {
+		problems = append(problems, "No Vencord checkout or bundle at "+offlineRepoSource()+", reload plugins online once or pass --repo-bundle")
+	}
+
+	plugins, err := readRemoteList()
+	if err != nil {
+		return err
+	}
+	for _, plugin := range plugins {
+		if _, err := os.Stat(remoteCachePath(plugin)); err != nil {
+			problems = append(problems, "Remote plugin "+plugin+" was never downloaded")
+		}
+	}
+
+	if usesManagedToolchain() && cli.NodeTarball == "" {
+		if _, err := loadToolchain(); err != nil {
+			problems = append(problems, "No managed toolchain yet, reload plugins online once or pass --node-tarball and --pnpm-tarball")
+		}
+	}
+
+	if len(problems) != 0 {
+		return wrapError(categoryPrerequisite, "Can't rebuild offline", errors.New(strings.Join(problems, "\n")))
+	}
+
+	log.Info("Everything needed to rebuild offline is here")
+	return nil
+}
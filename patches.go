@@ -0,0 +1,118 @@
+/*
+	Venjector: Copyright (C) 2023 tizu69
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/charmbracelet/log"
+)
+
+// Venjector changes a few of Vencord's own files. Each change is anchored on a bit of Vencord's code, so the rest of
+// the file stays whatever Vencord has now. If an anchor doesn't fit anymore, the build stops and says which one,
+// instead of building something that's half ours and half outdated. That can't be skipped either.
+
+// sourceEdit replaces what Anchor matches, which has to be exactly one spot. Replace can use $1 and friends.
+type sourceEdit struct {
+	What    string // what it's for, for errors
+	Anchor  *regexp.Regexp
+	Replace string
+}
+
+// sourcePatch is every edit to one file, File is relative to the checkout.
+type sourcePatch struct {
+	File  string
+	Edits []sourceEdit
+}
+
+// importEdit adds an import right after the license header. Imports are hoisted anyway, so any spot works.
+func importEdit(what, line string) sourceEdit {
+	return sourceEdit{what, regexp.MustCompile(`\A(/\*[\s\S]*?\*/\n)?`), "${1}\n" + line + "\n"}
+}
+
+// Settings tabs, applied when copying core
+var corePatches = []sourcePatch{
+	{
+		File: "src/components/VencordSettings/UpdaterTab.tsx",
+		Edits: []sourceEdit{
+			importEdit("import the updater", `import { updateWithVenjector } from "../../userplugins/core";`),
+			{
+				"keep track of the update's progress",
+				regexp.MustCompile(`(?m)^(\s*)const \[isUpdating, setIsUpdating\] = React\.useState\(false\);\n`),
+				"${0}${1}const [updateProgress, setUpdateProgress] = React.useState<string | null>(null);\n",
+			},
+			{
+				"update with Venjector",
+				regexp.MustCompile(`\bawait update\(\)`),
+				"await updateWithVenjector(setUpdateProgress)",
+			},
+			{
+				// Vencord only shows it when there's an update, Venjector can always rebuild
+				"always show the update button",
+				regexp.MustCompile(`\{isOutdated && (\(?\s*<Button\b)`),
+				"{${1}",
+			},
+			{
+				"show the update's progress",
+				regexp.MustCompile(`>(\s*)Update Now(\s*)<`),
+				`>${1}{updateProgress ?? <>{isOutdated ? "Update" : "Reinstall current version"} using Venjector</>}${2}<`,
+			},
+		},
+	},
+	{
+		File: "src/components/PluginSettings/index.tsx",
+		Edits: []sourceEdit{
+			importEdit("import the buttons", `import { VenjectorUtils } from "../../userplugins/core";`),
+			{
+				"add the buttons",
+				regexp.MustCompile(`(?m)^(\s*)<ReloadRequiredCard\b[^>]*/>\n`),
+				"${0}\n${1}<VenjectorUtils />\n",
+			},
+		},
+	},
+}
+
+// applyPatch makes every edit of p to the checkout in repoLocation. The file is only written if they all fit.
+func applyPatch(repoLocation string, p sourcePatch) error {
+	path := filepath.Join(repoLocation, filepath.FromSlash(p.File))
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return wrapUnskippable(categoryBuild, "Vencord doesn't have "+p.File+" anymore, Venjector needs an update", err)
+	} else if err != nil {
+		return wrapError(categoryFilesystem, "Failed to read "+p.File, err)
+	}
+
+	contents := string(data)
+	for _, e := range p.Edits {
+		if n := len(e.Anchor.FindAllStringIndex(contents, -1)); n != 1 {
+			return wrapUnskippable(categoryBuild, "Vencord changed "+p.File+", Venjector needs an update",
+				fmt.Errorf("can't %s: %s matched %d times instead of once", e.What, e.Anchor, n))
+		}
+		contents = e.Anchor.ReplaceAllString(contents, e.Replace)
+		log.Info("Patched", "file", p.File, "edit", e.What)
+	}
+
+	if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+		return wrapError(categoryFilesystem, "Failed to write "+p.File, err)
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+/*
+	Venjector: Copyright (C) 2023 tizu69
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	cp "github.com/otiai10/copy"
+)
+
+// vencordFixture copies testdata/vencord, the files corePatches edit as Vencord has them, into a temporary checkout.
+func vencordFixture(t *testing.T) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	if err := cp.Copy(filepath.Join("testdata", "vencord"), dir); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestCorePatches(t *testing.T) {
+	dir := vencordFixture(t)
+
+	for _, p := range corePatches {
+		if err := applyPatch(dir, p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	updater := string(readFile(t, filepath.Join(dir, "src", "components", "VencordSettings", "UpdaterTab.tsx")))
+	for _, want := range []string{
+		"\nimport { updateWithVenjector } from \"../../userplugins/core\";\n",
+		"const [updateProgress, setUpdateProgress] = React.useState<string | null>(null);",
+		"if (await updateWithVenjector(setUpdateProgress))",
+		`{updateProgress ?? <>{isOutdated ? "Update" : "Reinstall current version"} using Venjector</>}`,
+	} {
+		if !strings.Contains(updater, want) {
+			t.Errorf("UpdaterTab.tsx doesn't have %q", want)
+		}
+	}
+	for _, gone := range []string{"isOutdated && <Button", "Update Now", "await update()"} {
+		if strings.Contains(updater, gone) {
+			t.Errorf("UpdaterTab.tsx still has %q", gone)
+		}
+	}
+
+	plugins := string(readFile(t, filepath.Join(dir, "src", "components", "PluginSettings", "index.tsx")))
+	for _, want := range []string{
+		"\nimport { VenjectorUtils } from \"../../userplugins/core\";\n",
+		"<ReloadRequiredCard required={changes.hasChanges} />\n\n            <VenjectorUtils />\n",
+	} {
+		if !strings.Contains(plugins, want) {
+			t.Errorf("PluginSettings/index.tsx doesn't have %q", want)
+		}
+	}
+}
+
+func TestUpdateButtonAlwaysShown(t *testing.T) {
+	var edit sourceEdit
+	for _, e := range corePatches[0].Edits {
+		if e.What == "always show the update button" {
+			edit = e
+		}
+	}
+
+	for _, jsx := range []string{
+		"{isOutdated && <Button\n    size={Button.Sizes.SMALL}>\n    Update Now\n</Button>}",
+		"{isOutdated && (\n    <Button size={Button.Sizes.SMALL}>\n        Update Now\n    </Button>\n)}",
+	} {
+		got := edit.Anchor.ReplaceAllString(jsx, edit.Replace)
+		if strings.Contains(got, "isOutdated") || !strings.HasPrefix(got, "{") || !strings.HasSuffix(got, "}") {
+			t.Errorf("%q became %q", jsx, got)
+		}
+	}
+}
+
+func TestPatchAnchorGone(t *testing.T) {
+	dir := vencordFixture(t)
+	path := filepath.Join(dir, "src", "components", "PluginSettings", "index.tsx")
+	changed := strings.Replace(string(readFile(t, path)), "<ReloadRequiredCard", "<RestartCard", -1)
+	if err := os.WriteFile(path, []byte(changed), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	err := applyPatch(dir, corePatches[1])
+	if err == nil {
+		t.Fatal("patched without the anchor")
+	}
+	if skippable(err) {
+		t.Errorf("%v can be skipped, leaving a half patched build", err)
+	}
+	if got := string(readFile(t, path)); got != changed {
+		t.Error("the file was written anyway")
+	}
+}
+
+func TestPatchFileGone(t *testing.T) {
+	err := applyPatch(t.TempDir(), corePatches[0])
+	if err == nil {
+		t.Fatal("patched a file that isn't there")
+	}
+	if skippable(err) {
+		t.Errorf("%v can be skipped, leaving a half patched build", err)
+	}
+}
@@ -0,0 +1,224 @@
+/*
+	Venjector: Copyright (C) 2023 tizu69
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/charmbracelet/log"
+	cp "github.com/otiai10/copy"
+)
+
+// Venjector keeps things in three places:
+// - config: what you edit, like overrides, remote.json and config.json
+// - data: what it builds and needs to keep, like the Vencord checkout, the managed toolchain and logs
+// - cache: what it can download again, like remote plugins
+// On Linux, they follow the XDG base directory spec. Elsewhere, config and data are the same directory.
+// --data-dir puts all three in one directory of your choice.
+
+// With --local-data, everything goes here, next to wherever Venjector is run from
+const localDataDir = "venjectorConfig"
+
+// getHomePath is the one directory everything goes in with --data-dir (or VENJECTOR_HOME), or "" for the usual places.
+func getHomePath() string {
+	switch {
+	case cli.DataDir != "":
+		return cli.DataDir
+	case cli.LocalData:
+		abs, err := filepath.Abs(localDataDir)
+		if err != nil {
+			return localDataDir
+		}
+		return abs
+	}
+	return ""
+}
+
+// prepareHomePath checks that the --data-dir directory can be used, and creates it.
+func prepareHomePath() error {
+	home := getHomePath()
+	if home == "" {
+		return nil
+	}
+
+	if info, err := os.Stat(home); err == nil && !info.IsDir() {
+		return wrapError(categoryPrerequisite, "The data directory is a file", errors.New(home))
+	}
+	if err := os.MkdirAll(home, 0755); err != nil {
+		return wrapError(categoryFilesystem, "Failed to create the data directory "+home, err)
+	}
+
+	// Writable? Better to find out now than halfway through a rebuild
+	f, err := os.CreateTemp(home, ".venjector-*")
+	if err != nil {
+		return wrapError(categoryPermission, "Can't write to the data directory "+home, err)
+	}
+	f.Close()
+	os.Remove(f.Name())
+
+	log.Info("Using data directory", "path", home)
+	return nil
+}
+
+// xdgPath is $env/Venjector, or ~/fallback/Venjector if env isn't set. Relative paths are invalid, says the spec.
+func xdgPath(env, fallback string) string {
+	if dir := os.Getenv(env); filepath.IsAbs(dir) {
+		return filepath.Join(dir, "Venjector")
+	}
+	return filepath.Join(os.Getenv("HOME"), fallback, "Venjector")
+}
+
+func getConfigPath() string {
+	switch {
+	case cli.ConfigHome != "":
+		return cli.ConfigHome
+	case getHomePath() != "":
+		return getHomePath()
+	}
+
+	switch runtime.GOOS {
+	case "linux":
+		return xdgPath("XDG_CONFIG_HOME", ".config")
+	case "darwin":
+		return filepath.Join(os.Getenv("HOME"), "Library/Application Support/Venjector")
+	case "windows":
+		return filepath.Join(os.Getenv("LOCALAPPDATA"), "Venjector")
+	}
+
+	fatal(wrapError(categoryPrerequisite, "Unsupported OS", errors.New(runtime.GOOS)))
+	return ""
+}
+
+func getDataPath() string {
+	switch {
+	case cli.DataHome != "":
+		return cli.DataHome
+	case getHomePath() != "":
+		return getHomePath()
+	case runtime.GOOS == "linux":
+		return xdgPath("XDG_DATA_HOME", ".local/share")
+	}
+	return getConfigPath()
+}
+
+func getCachePath() string {
+	switch {
+	case cli.CacheHome != "":
+		return cli.CacheHome
+	case getHomePath() != "":
+		return filepath.Join(getHomePath(), "cache")
+	case runtime.GOOS == "linux":
+		return xdgPath("XDG_CACHE_HOME", ".cache")
+	case runtime.GOOS == "darwin":
+		return filepath.Join(os.Getenv("HOME"), "Library/Caches/Venjector")
+	}
+	return filepath.Join(getConfigPath(), "cache")
+}
+
+// migrateLayout moves things from where older Venjectors kept them, everything in ~/.config/Venjector, to where
+// they go now. It only does anything the first time, and not with the directories overridden.
+func migrateLayout() error {
+	if getHomePath() != "" || cli.ConfigHome != "" || cli.DataHome != "" || cli.CacheHome != "" || runtime.GOOS != "linux" {
+		return nil
+	}
+
+	legacy := filepath.Join(os.Getenv("HOME"), ".config/Venjector")
+	if err := moveIfMissing(legacy, getConfigPath()); err != nil {
+		return err
+	}
+
+	config, data := getConfigPath(), getDataPath()
+	if _, err := os.Stat(filepath.Join(config, repoDir)); err != nil {
+		return nil // nothing built there, so nothing to move
+	}
+
+	// Everything injected with the build we're moving has to follow it
+	injected := []client{}
+	for _, c := range findClients() {
+		rel, err := filepath.Rel(filepath.Join(config, repoDir), getStatus(c).Target)
+		if err == nil && !strings.HasPrefix(rel, "..") {
+			injected = append(injected, c)
+		}
+	}
+
+	log.Info("Moving data to follow the XDG base directory spec", "config", config, "data", data, "cache", getCachePath())
+	os.RemoveAll(filepath.Join(config, stagingDir))
+	for _, name := range []string{repoDir, repoDir + ".old", "logs", "toolchain"} {
+		if err := moveIfMissing(filepath.Join(config, name), filepath.Join(data, name)); err != nil {
+			return err
+		}
+	}
+	if err := relocateToolchain(filepath.Join(config, "toolchain")); err != nil {
+		return err
+	}
+	if err := moveIfMissing(filepath.Join(config, "cache"), getCachePath()); err != nil {
+		return err
+	}
+
+	for _, c := range injected {
+		if err := injectClient(c); err != nil {
+			log.Warn("Failed to point client at the moved build, install again", "client", c.Name, "err", err)
+		}
+	}
+	return nil
+}
+
+// moveIfMissing moves from to to, if there is a from and no to yet.
+func moveIfMissing(from, to string) error {
+	if _, err := os.Stat(from); err != nil {
+		return nil
+	} else if _, err := os.Stat(to); err == nil {
+		return nil
+	} else if samePath(from, to) {
+		return nil
+	}
+
+	log.Info("Moving", "from", from, "to", to)
+	if err := os.MkdirAll(filepath.Dir(to), 0755); err != nil {
+		return wrapError(categoryFilesystem, "Failed to create "+filepath.Dir(to), err)
+	}
+	if err := os.Rename(from, to); err == nil {
+		return nil
+	}
+
+	// Probably different filesystems
+	if err := cp.Copy(from, to); err != nil {
+		os.RemoveAll(to)
+		return wrapError(categoryFilesystem, "Failed to move "+from, err)
+	}
+	return wrapError(categoryFilesystem, "Failed to remove "+from, os.RemoveAll(from))
+}
+
+// relocateToolchain fixes the paths in a toolchain that was moved from old.
+func relocateToolchain(old string) error {
+	tc, err := loadToolchain()
+	if err != nil {
+		return nil // there's none
+	}
+
+	for _, path := range []*string{&tc.Node, &tc.Pnpm} {
+		if rel, err := filepath.Rel(old, *path); err == nil && !strings.HasPrefix(rel, "..") {
+			*path = filepath.Join(getToolchainPath(), rel)
+		}
+	}
+	return saveToolchain(tc)
+}
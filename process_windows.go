@@ -0,0 +1,48 @@
+//go:build windows
+
+/*
+	Venjector: Copyright (C) 2023 tizu69
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"os/exec"
+	"strconv"
+	"syscall"
+)
+
+func setProcessGroup(command *exec.Cmd) {
+	command.SysProcAttr = &syscall.SysProcAttr{CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP}
+	command.Cancel = func() error {
+		// There are no process groups to signal, but taskkill can take down the whole tree
+		return exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(command.Process.Pid)).Run()
+	}
+}
+
+// processAlive tells if there's a process with the PID pid.
+func processAlive(pid int) bool {
+	const queryLimitedInformation, stillActive = 0x1000, 259
+
+	handle, err := syscall.OpenProcess(queryLimitedInformation, false, uint32(pid))
+	if err != nil {
+		return err == syscall.ERROR_ACCESS_DENIED // it's there, just not ours
+	}
+	defer syscall.CloseHandle(handle)
+
+	var code uint32
+	return syscall.GetExitCodeProcess(handle, &code) == nil && code == stillActive
+}
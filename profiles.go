@@ -0,0 +1,400 @@
+/*
+	Venjector: Copyright (C) 2023 tizu69
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/charmbracelet/log"
+	"github.com/ncruces/zenity"
+	cp "github.com/otiai10/copy"
+)
+
+// A profile is a plugin set with its own overrides, remote.json and build. The default profile lives right in
+// the config and data directories, like before there were profiles, the others in 'profiles/<name>' in them.
+const defaultProfile = "default"
+
+var profileNamePattern = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// profile is profile.json, the settings of a profile.
+type profile struct {
+	Ref    string `json:"ref,omitempty"`    // Vencord branch, tag or commit to build, the default branch if empty
+	Client string `json:"client,omitempty"` // Discord install or Vesktop config directory to inject after building
+
+	Vars map[string]string `json:"vars,omitempty"` // reload-time vars, see vars.go
+}
+
+func currentProfile() string {
+	if cli.Profile == "" {
+		return defaultProfile
+	}
+	return cli.Profile
+}
+
+// profileDir is where the profile name keeps its things in base, the config or data directory.
+func profileDir(base, name string) string {
+	if name == defaultProfile {
+		return base
+	}
+	return filepath.Join(base, "profiles", name)
+}
+
+// getProfilePath is the current profile's config: overrides, remote.json and profile.json.
+func getProfilePath() string {
+	return profileDir(getConfigPath(), currentProfile())
+}
+
+// getBuildPath is where the current profile's Vencord checkouts are.
+func getBuildPath() string {
+	return profileDir(getDataPath(), currentProfile())
+}
+
+func profileExists(name string) bool {
+	if name == defaultProfile {
+		return true
+	}
+	info, err := os.Stat(profileDir(getConfigPath(), name))
+	return err == nil && info.IsDir()
+}
+
+func checkProfileName(name string) error {
+	if !profileNamePattern.MatchString(name) || name == "." || name == ".." {
+		return wrapError(categoryPrerequisite, "Invalid profile name, use letters, numbers, '.', '_' and '-'",
+			errors.New(name))
+	}
+	return nil
+}
+
+// listProfiles returns the names of all profiles, default first.
+func listProfiles() []string {
+	names := []string{}
+	entries, _ := os.ReadDir(filepath.Join(getConfigPath(), "profiles"))
+	for _, entry := range entries {
+		if entry.IsDir() && profileNamePattern.MatchString(entry.Name()) {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	return append([]string{defaultProfile}, names...)
+}
+
+func loadProfile(name string) (profile, error) {
+	p := profile{}
+	data, err := os.ReadFile(filepath.Join(profileDir(getConfigPath(), name), "profile.json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return p, nil
+	} else if err != nil {
+		return p, wrapError(categoryFilesystem, "Failed to read profile.json", err)
+	}
+
+	if err := json.Unmarshal(data, &p); err != nil {
+		return p, wrapError(categoryFilesystem, "Failed to read profile.json", err)
+	}
+	return p, nil
+}
+
+func saveProfile(name string, p profile) error {
+	data, err := json.MarshalIndent(p, "", "\t")
+	if err != nil {
+		return wrapError(categoryUnknown, "Failed to marshal profile.json", err)
+	}
+
+	dir := profileDir(getConfigPath(), name)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return wrapError(categoryFilesystem, "Failed to create "+dir, err)
+	}
+	err = os.WriteFile(filepath.Join(dir, "profile.json"), data, 0644)
+	return wrapError(categoryFilesystem, "Failed to write profile.json", err)
+}
+
+// createProfile makes the profile name, a copy of the profile from if that's not empty. Builds aren't copied.
+func createProfile(name, from string) error {
+	if err := checkProfileName(name); err != nil {
+		return err
+	} else if profileExists(name) {
+		return wrapError(categoryPrerequisite, "There already is a profile named "+name, os.ErrExist)
+	}
+
+	dir := profileDir(getConfigPath(), name)
+	if from == "" {
+		log.Info("Creating profile", "name", name)
+		return saveProfile(name, profile{})
+	} else if !profileExists(from) {
+		return wrapError(categoryPrerequisite, "No profile named "+from, os.ErrNotExist)
+	}
+
+	log.Info("Cloning profile", "from", from, "to", name)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return wrapError(categoryFilesystem, "Failed to create "+dir, err)
+	}
+	for _, file := range []string{"overrides", "remote.json", "profile.json"} {
+		source := filepath.Join(profileDir(getConfigPath(), from), file)
+		if _, err := os.Stat(source); err != nil {
+			continue
+		}
+		if err := cp.Copy(source, filepath.Join(dir, file)); err != nil {
+			return wrapError(categoryFilesystem, "Failed to copy "+file, err)
+		}
+	}
+	return saveProfileIfMissing(name)
+}
+
+func saveProfileIfMissing(name string) error {
+	if _, err := os.Stat(filepath.Join(profileDir(getConfigPath(), name), "profile.json")); err == nil {
+		return nil
+	}
+	return saveProfile(name, profile{})
+}
+
+// deleteProfile removes a profile with its build. Clients injected with it are left alone, status shows them as stale.
+func deleteProfile(name string) error {
+	switch {
+	case name == defaultProfile:
+		return wrapError(categoryPrerequisite, "The default profile can't be deleted", errors.New(name))
+	case name == currentProfile():
+		return wrapError(categoryPrerequisite, "Switch to another profile before deleting this one", errors.New(name))
+	case !profileExists(name):
+		return wrapError(categoryPrerequisite, "No profile named "+name, os.ErrNotExist)
+	}
+
+	log.Info("Deleting profile", "name", name)
+	for _, dir := range []string{profileDir(getDataPath(), name), profileDir(getConfigPath(), name)} {
+		if err := os.RemoveAll(dir); err != nil {
+			return wrapError(categoryFilesystem, "Failed to delete "+dir, err)
+		}
+	}
+	return nil
+}
+
+// checkoutProfileRef checks out the current profile's ref in the freshly cloned staging checkout.
+func checkoutProfileRef(dir string) error {
+	p, err := loadProfile(currentProfile())
+	if err != nil || p.Ref == "" {
+		return err
+	}
+
+	log.Info("Checking out", "ref", p.Ref)
+	command := newCommand("git", "checkout", "--detach", p.Ref)
+	command.Dir = dir
+	if err := runLogged("git-checkout", command, nil); err != nil {
+		return wrapError(categoryPrerequisite, "Vencord has no branch, tag or commit "+p.Ref, err)
+	}
+	return nil
+}
+
+// injectProfileClient injects the current profile's target client with the new build, if it has one.
+func injectProfileClient() error {
+	p, err := loadProfile(currentProfile())
+	if err != nil || p.Client == "" {
+		return err
+	}
+
+	c, err := clientAt(p.Client)
+	if err != nil {
+		return err
+	}
+	return injectClient(c)
+}
+
+// profileCommand is 'venjector profile ...'.
+func profileCommand(command string) error {
+	switch command {
+	case "profile create <name>":
+		if err := createProfile(cli.Profiles.Create.Name, cli.Profiles.Create.From); err != nil {
+			return err
+		}
+		return setProfile(cli.Profiles.Create.Name, cli.Profiles.Create.Ref, cli.Profiles.Create.Client)
+	case "profile set <name>":
+		if !profileExists(cli.Profiles.Set.Name) {
+			return wrapError(categoryPrerequisite, "No profile named "+cli.Profiles.Set.Name, os.ErrNotExist)
+		}
+		return setProfile(cli.Profiles.Set.Name, cli.Profiles.Set.Ref, cli.Profiles.Set.Client)
+	case "profile delete <name>":
+		return deleteProfile(cli.Profiles.Delete.Name)
+	}
+
+	type listed struct {
+		Name    string `json:"name"`
+		Current bool   `json:"current"`
+		profile
+	}
+	profiles := []listed{}
+	for _, name := range listProfiles() {
+		p, err := loadProfile(name)
+		if err != nil {
+			return err
+		}
+		profiles = append(profiles, listed{name, name == currentProfile(), p})
+	}
+
+	if cli.JSON {
+		if err := json.NewEncoder(os.Stdout).Encode(profiles); err != nil {
+			return wrapError(categoryUnknown, "Failed to print profiles", err)
+		}
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "\tPROFILE\tREF\tCLIENT")
+	for _, p := range profiles {
+		mark := ""
+		if p.Current {
+			mark = "*"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", mark, p.Name, p.Ref, p.Client)
+	}
+	return tw.Flush()
+}
+
+// setProfile changes the settings of a profile, "-" clears one.
+func setProfile(name, ref, client string) error {
+	p, err := loadProfile(name)
+	if err != nil {
+		return err
+	}
+
+	if ref == "-" {
+		p.Ref = ""
+	} else if ref != "" {
+		p.Ref = ref
+	}
+	if client == "-" {
+		p.Client = ""
+	} else if client != "" {
+		c, err := clientAt(client)
+		if err != nil {
+			return err
+		}
+		p.Client = c.Path
+	}
+	return saveProfile(name, p)
+}
+
+// manageProfiles is the 'Manage profiles' menu.
+func manageProfiles() {
+	const newProfile = "New profile..."
+
+	for {
+		items := []string{}
+		for _, name := range listProfiles() {
+			if name == currentProfile() {
+				name += " (current)"
+			}
+			items = append(items, name)
+		}
+
+		sel, err := zenity.List("Profiles (Venjector)\nEach profile has its own plugins and build.",
+			append(items, newProfile), zenity.Title("Venjector"), zenity.DisallowEmpty(),
+			zenity.OKLabel("Select"), zenity.CancelLabel("Done"))
+		if err != nil {
+			return
+		}
+
+		if sel == newProfile {
+			name, err := zenity.Entry("Name of the new profile", zenity.Title("Venjector"))
+			if err != nil {
+				continue
+			}
+			if err := createProfile(name, ""); err != nil {
+				zenity.Error(err.Error(), zenity.Title("Venjector"))
+			}
+			continue
+		}
+
+		name := strings.TrimSuffix(sel, " (current)")
+		if err := manageProfile(name); err != nil {
+			zenity.Error(err.Error(), zenity.Title("Venjector"))
+		}
+	}
+}
+
+func manageProfile(name string) error {
+	const (
+		actionSwitch = "Switch to it"
+		actionClone  = "Clone it"
+		actionRef    = "Set Vencord branch, tag or commit"
+		actionClient = "Set client to inject after reloading"
+		actionDelete = "Delete it"
+	)
+
+	p, err := loadProfile(name)
+	if err != nil {
+		return err
+	}
+
+	ref, client := p.Ref, p.Client
+	if ref == "" {
+		ref = "default branch"
+	}
+	if client == "" {
+		client = "none"
+	}
+
+	action, err := zenity.List(fmt.Sprintf("Profile %s\nVencord: %s\nClient: %s", name, ref, client),
+		[]string{actionSwitch, actionClone, actionRef, actionClient, actionDelete},
+		zenity.Title("Venjector"), zenity.DisallowEmpty(), zenity.CancelLabel("Back"))
+	if err != nil {
+		return nil
+	}
+
+	switch action {
+	case actionSwitch:
+		log.Info("Switching profile", "to", name)
+		cli.Profile = name
+		pickedVesktop = nil
+	case actionClone:
+		clone, err := zenity.Entry("Name of the copy of "+name, zenity.Title("Venjector"))
+		if err == nil {
+			return createProfile(clone, name)
+		}
+	case actionRef:
+		ref, err := zenity.Entry("Vencord branch, tag or commit to build, empty for the default branch",
+			zenity.Title("Venjector"), zenity.EntryText(p.Ref))
+		if err == nil {
+			p.Ref = strings.TrimSpace(ref)
+			return saveProfile(name, p)
+		}
+	case actionClient:
+		const noClient = "None"
+		items := []string{noClient}
+		for _, c := range findClients() {
+			if !c.ReadOnly {
+				items = append(items, c.Path)
+			}
+		}
+		sel, err := zenity.List("Client to inject after reloading "+name, items,
+			zenity.Title("Venjector"), zenity.DisallowEmpty(), zenity.Width(768))
+		if err == nil {
+			p.Client = strings.TrimPrefix(sel, noClient)
+			return saveProfile(name, p)
+		}
+	case actionDelete:
+		if zenity.Question("Delete profile "+name+" with its plugins and build?", zenity.Title("Venjector")) == nil {
+			return deleteProfile(name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+/*
+	Venjector: Copyright (C) 2023 tizu69
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+type stepResult struct {
+	Task     string `json:"task"`
+	Duration int64  `json:"durationMs"`
+	Attempts int    `json:"attempts"`
+	Skipped  bool   `json:"skipped,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+type errorResult struct {
+	Category string `json:"category"`
+	Message  string `json:"message"`
+}
+
+// runResult is what --json prints when Venjector exits. The in-client updater tab reads it, so only ever add fields.
+type runResult struct {
+	Success  bool          `json:"success"`
+	ExitCode int           `json:"exitCode"`
+	Choice   int           `json:"choice"`
+	Duration int64         `json:"durationMs"`
+	Steps    []stepResult  `json:"steps"`
+	Warnings []string      `json:"warnings"`
+	Errors   []errorResult `json:"errors"`
+	Commit   string        `json:"commit,omitempty"`
+}
+
+var (
+	result    = runResult{Choice: -1, Steps: []stepResult{}, Warnings: []string{}, Errors: []errorResult{}}
+	startTime = time.Now()
+)
+
+func addWarning(warning string) {
+	log.Warn(warning)
+	result.Warnings = append(result.Warnings, warning)
+}
+
+func addError(err error) {
+	result.Errors = append(result.Errors, errorResult{Category: categoryOf(err).String(), Message: err.Error()})
+}
+
+// builtCommit returns the commit hash of the Vencord checkout, or an empty string if there is none.
+func builtCommit() string {
+	return checkoutCommit(filepath.Join(getBuildPath(), repoDir))
+}
+
+// checkoutCommit returns the commit hash of the checkout in dir, or an empty string if there is none.
+func checkoutCommit(dir string) string {
+	command := newCommand("git", "rev-parse", "HEAD")
+	command.Dir = dir
+
+	buf := new(bytes.Buffer)
+	command.Stdout = buf
+
+	if err := command.Run(); err != nil {
+		return ""
+	}
+	return strings.TrimSpace(buf.String())
+}
+
+// exit prints the result if --json was given, then exits with code.
+func exit(code int) {
+	if cli.JSON {
+		result.Success = code == exitOK
+		result.ExitCode = code
+		result.Duration = time.Since(startTime).Milliseconds()
+
+		err := json.NewEncoder(os.Stdout).Encode(result)
+		if err != nil {
+			log.Error("Failed to print result", "err", err)
+		}
+	}
+
+	unlockDataDir()
+	os.Exit(code)
+}
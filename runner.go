@@ -0,0 +1,184 @@
+/*
+	Venjector: Copyright (C) 2023 tizu69
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+// runTask is one package manager invocation, like 'pnpm install' in the staging checkout.
+type runTask struct {
+	Name     string   // used for logs, like "pnpm-install"
+	Dir      string   // working directory
+	Args     []string // pnpm's arguments
+	Env      []string // added to our own environment
+	Offline  bool     // don't touch the network, only supported by install
+	Timeout  time.Duration
+	Progress lineProgress
+}
+
+// runner runs pnpm, however it is we get at it.
+type runner interface {
+	Name() string
+	Run(task runTask) error
+}
+
+// systemRunner uses the pnpm in PATH.
+type systemRunner struct{}
+
+func (systemRunner) Name() string { return "system" }
+
+func (systemRunner) Run(task runTask) error {
+	return execTask(task, "pnpm")
+}
+
+// corepackRunner lets corepack pick the pnpm version from Vencord's packageManager field.
+type corepackRunner struct{}
+
+func (corepackRunner) Name() string { return "corepack" }
+
+func (corepackRunner) Run(task runTask) error {
+	// Don't ask before downloading the pinned version, there's nobody to answer
+	task.Env = append(task.Env, "COREPACK_ENABLE_DOWNLOAD_PROMPT=0")
+	return execTask(task, "corepack", "pnpm")
+}
+
+// managedRunner uses the private Node.js and pnpm from the managed toolchain.
+type managedRunner struct {
+	tc *toolchain
+}
+
+func (managedRunner) Name() string { return "managed" }
+
+func (r managedRunner) Run(task runTask) error {
+	shims, err := writePnpmShim(r.tc)
+	if err != nil {
+		return err
+	}
+
+	// Scripts pnpm runs need to find our node too, and our pnpm, Vencord's call 'pnpm ...' themselves
+	path := []string{shims, filepath.Dir(r.tc.Node), os.Getenv("PATH")}
+	task.Env = append(task.Env, "PATH="+strings.Join(path, string(os.PathListSeparator)))
+	return execTask(task, r.tc.Node, r.tc.Pnpm)
+}
+
+// fakeRunner runs nothing. It remembers what it was asked to do and fails with Err, if set.
+type fakeRunner struct {
+	Tasks []runTask
+	Err   error
+}
+
+func (*fakeRunner) Name() string { return "dry-run" }
+
+func (r *fakeRunner) Run(task runTask) error {
+	log.Info("Not running (dry run)", "task", task.Name, "dir", task.Dir, "args", strings.Join(task.Args, " "))
+	r.Tasks = append(r.Tasks, task)
+	return r.Err
+}
+
+// dryRunner is the runner for --runner dry-run. There's one for the whole run, so it knows everything it skipped.
+var dryRunner = &fakeRunner{}
+
+// isDryRun tells if pnpm isn't really run, and so nothing gets built.
+func isDryRun() bool {
+	return cli.Runner == "dry-run"
+}
+
+// unlessDryRun skips run in a dry run. For steps that use what was built, like putting it in place of the last build.
+func unlessDryRun(run func() error) func() error {
+	return func() error {
+		if isDryRun() {
+			log.Info("Nothing was built, skipping (dry run)")
+			return nil
+		}
+		return run()
+	}
+}
+
+// usesManagedToolchain tells if the managed toolchain needs to be provisioned.
+func usesManagedToolchain() bool {
+	return cli.Runner == "managed" || (cli.Runner == "auto" && cli.ManagedToolchain)
+}
+
+// getRunner returns the runner picked with --runner. 'auto' is the managed toolchain with --managed-toolchain,
+// and the system pnpm otherwise.
+func getRunner() (runner, error) {
+	switch {
+	case usesManagedToolchain():
+		tc, err := loadToolchain()
+		if err != nil {
+			return nil, err
+		}
+		return managedRunner{tc}, nil
+	case cli.Runner == "corepack":
+		return corepackRunner{}, nil
+	case isDryRun():
+		return dryRunner, nil
+	}
+	return systemRunner{}, nil
+}
+
+// runPnpm runs a task with the runner picked with --runner.
+func runPnpm(task runTask) error {
+	r, err := getRunner()
+	if err != nil {
+		return err
+	}
+
+	if task.Timeout == 0 {
+		task.Timeout = cli.StepTimeout
+	}
+
+	log.Info("Running pnpm", "runner", r.Name(), "task", task.Name, "args", strings.Join(task.Args, " "))
+	return r.Run(task)
+}
+
+// execTask runs program with prefix and the task's arguments, with everything the task asks for.
+func execTask(task runTask, program string, prefix ...string) error {
+	ctx := runCtx
+	if task.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(runCtx, task.Timeout)
+		defer cancel()
+	}
+
+	args := append(prefix, task.Args...)
+	if task.Offline {
+		args = append(args, "--offline")
+	}
+
+	command := newCommandContext(ctx, program, args...)
+	command.Dir = task.Dir
+	if len(task.Env) != 0 {
+		command.Env = append(os.Environ(), task.Env...)
+	}
+
+	err := runLogged(task.Name, command, task.Progress)
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("timed out after %s: %w", task.Timeout, err)
+	}
+	return err
+}
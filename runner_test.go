@@ -0,0 +1,142 @@
+/*
+	Venjector: Copyright (C) 2023 tizu69
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// useDryRun points Venjector at a temporary data directory and a fresh fakeRunner, and returns the runner.
+func useDryRun(t *testing.T) *fakeRunner {
+	t.Helper()
+
+	savedCli, savedRunner := cli, dryRunner
+	t.Cleanup(func() { cli, dryRunner = savedCli, savedRunner })
+
+	cli.DataDir = t.TempDir()
+	cli.Profile = defaultProfile
+	cli.Runner = "dry-run"
+	cli.StepTimeout = time.Minute
+	dryRunner = &fakeRunner{}
+	return dryRunner
+}
+
+func TestDryRunRecordsTasks(t *testing.T) {
+	fake := useDryRun(t)
+
+	for _, step := range []func() error{pnpmInstall, pnpmTest, pnpmBuild} {
+		if err := step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	staging := filepath.Join(getBuildPath(), stagingDir)
+	want := []struct {
+		name string
+		args []string
+	}{
+		{"pnpm-install", []string{"install", "--frozen-lockfile"}},
+		{"pnpm-test", []string{"test"}},
+		{"pnpm-build", []string{"build"}},
+	}
+	if len(fake.Tasks) != len(want) {
+		t.Fatalf("got %d tasks, want %d", len(fake.Tasks), len(want))
+	}
+	for i, task := range fake.Tasks {
+		if task.Name != want[i].name || !slices.Equal(task.Args, want[i].args) {
+			t.Errorf("task %d is %s %v, want %s %v", i, task.Name, task.Args, want[i].name, want[i].args)
+		}
+		if task.Dir != staging {
+			t.Errorf("%s runs in %s, want %s", task.Name, task.Dir, staging)
+		}
+		if task.Timeout != cli.StepTimeout {
+			t.Errorf("%s has timeout %s, want --step-timeout", task.Name, task.Timeout)
+		}
+		if task.Offline {
+			t.Errorf("%s is offline without --offline", task.Name)
+		}
+	}
+}
+
+func TestDryRunOffline(t *testing.T) {
+	fake := useDryRun(t)
+	cli.Offline = true
+
+	if err := pnpmInstall(); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.Tasks) != 1 || !fake.Tasks[0].Offline {
+		t.Fatalf("install wasn't offline: %+v", fake.Tasks)
+	}
+}
+
+func TestDryRunFailures(t *testing.T) {
+	fake := useDryRun(t)
+	fake.Err = errors.New("boom")
+
+	for _, c := range []struct {
+		step    func() error
+		offline bool
+		want    errorCategory
+	}{
+		{pnpmInstall, false, categoryNetwork},
+		{pnpmInstall, true, categoryPrerequisite},
+		{pnpmBuild, false, categoryBuild},
+	} {
+		cli.Offline = c.offline
+		err := c.step()
+		if !errors.Is(err, fake.Err) {
+			t.Errorf("got %v, want it to wrap %v", err, fake.Err)
+		}
+		if got := categoryOf(err); got != c.want {
+			t.Errorf("%v is a %s error, want %s", err, got, c.want)
+		}
+	}
+}
+
+func TestDryRunTestsFailing(t *testing.T) {
+	fake := useDryRun(t)
+	fake.Err = errors.New("boom")
+
+	if err := pnpmTest(); err != nil {
+		t.Fatalf("failing tests stopped the build: %v", err)
+	}
+}
+
+func TestUnlessDryRun(t *testing.T) {
+	useDryRun(t)
+
+	ran := false
+	step := unlessDryRun(func() error {
+		ran = true
+		return nil
+	})
+
+	if err := step(); err != nil || ran {
+		t.Fatalf("ran in a dry run (err %v)", err)
+	}
+
+	cli.Runner = "system"
+	if err := step(); err != nil || !ran {
+		t.Fatalf("didn't run without a dry run (err %v)", err)
+	}
+}
@@ -0,0 +1,200 @@
+/*
+	Venjector: Copyright (C) 2023 tizu69
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/ncruces/zenity"
+)
+
+//go:embed templates/*
+var templates embed.FS
+
+// Plugin names end up as a JS identifier (VencordNative.pluginHelpers.Name), so they have to be one
+var pluginNamePattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9]*$`)
+
+// pluginTemplate is what the templates in templates/plugin get.
+type pluginTemplate struct {
+	Name        string
+	Description string
+	Author      string
+	Year        int
+
+	Settings bool
+	Patches  bool
+	Native   bool
+}
+
+// HelperName is what Vencord calls the native module: the directory name, capitalized.
+func (t pluginTemplate) HelperName() string {
+	return strings.ToUpper(t.Name[:1]) + t.Name[1:]
+}
+
+// TypesImport is the import from @utils/types, with what the plugin needs of it.
+func (t pluginTemplate) TypesImport() string {
+	names := []string{}
+	if t.Settings {
+		names = append(names, "OptionType")
+	}
+	if t.Native {
+		names = append(names, "PluginNative")
+	}
+
+	if len(names) == 0 {
+		return `import definePlugin from "@utils/types";`
+	}
+	return `import definePlugin, { ` + strings.Join(names, ", ") + ` } from "@utils/types";`
+}
+
+// newPlugin creates a plugin skeleton in overrides/src/userplugins/<name> and returns where.
+func newPlugin(t pluginTemplate) (string, error) {
+	if !pluginNamePattern.MatchString(t.Name) {
+		return "", wrapError(categoryPrerequisite, "Plugin names have to start with a letter and only have letters and numbers",
+			errors.New(t.Name))
+	}
+	if t.Description == "" {
+		t.Description = "My new plugin"
+	}
+	if t.Author == "" {
+		t.Author = gitUserName()
+	}
+	t.Year = time.Now().Year()
+
+	dir := filepath.Join(getProfilePath(), "overrides", "src", "userplugins", t.Name)
+	if _, err := os.Stat(dir); err == nil {
+		return "", wrapError(categoryPrerequisite, "There already is a plugin there", errors.New(dir))
+	}
+
+	files := map[string]string{"index.tsx": "index.tsx.tmpl"}
+	if t.Native {
+		files["native.ts"] = "native.ts.tmpl"
+	}
+
+	funcs := template.FuncMap{
+		// A JS string literal, JSON's are valid JS
+		"str": func(s string) (string, error) {
+			data, err := json.Marshal(s)
+			return string(data), err
+		},
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", wrapError(categoryFilesystem, "Failed to create "+dir, err)
+	}
+	for to, from := range files {
+		tmpl, err := template.New(from).Funcs(funcs).ParseFS(templates, "templates/plugin/"+from)
+		if err != nil {
+			return "", wrapError(categoryUnknown, "Failed to read template "+from, err)
+		}
+
+		buf := new(bytes.Buffer)
+		if err := tmpl.Execute(buf, t); err != nil {
+			return "", wrapError(categoryUnknown, "Failed to fill in template "+from, err)
+		}
+
+		if err := os.WriteFile(filepath.Join(dir, to), buf.Bytes(), 0644); err != nil {
+			return "", wrapError(categoryFilesystem, "Failed to write "+to, err)
+		}
+	}
+
+	log.Info("Created plugin", "name", t.Name, "location", dir)
+	return dir, nil
+}
+
+// gitUserName is who git thinks you are, for the authors of a new plugin.
+func gitUserName() string {
+	buf := new(bytes.Buffer)
+	command := newCommand("git", "config", "user.name")
+	command.Stdout = buf
+	if command.Run() != nil || strings.TrimSpace(buf.String()) == "" {
+		return "You"
+	}
+	return strings.TrimSpace(buf.String())
+}
+
+// newPluginCommand is 'venjector new-plugin'.
+func newPluginCommand() error {
+	dir, err := newPlugin(pluginTemplate{
+		Name:        cli.NewPlugin.Name,
+		Description: cli.NewPlugin.Description,
+		Author:      cli.NewPlugin.Author,
+		Settings:    cli.NewPlugin.Settings,
+		Patches:     cli.NewPlugin.Patches,
+		Native:      cli.NewPlugin.Native,
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(dir)
+	return nil
+}
+
+// newPluginDialog is the 'Create a new plugin' menu, it opens the new plugin's directory when done.
+func newPluginDialog() error {
+	const (
+		extraSettings = "Settings"
+		extraPatches  = "Patches to Discord's code"
+		extraNative   = "Native module, for Node.js APIs"
+	)
+
+	name, err := zenity.Entry("Name of the new plugin, like MyPlugin", zenity.Title("Venjector"))
+	if err != nil {
+		return nil
+	}
+	description, err := zenity.Entry("What does "+name+" do?", zenity.Title("Venjector"))
+	if err != nil {
+		return nil
+	}
+	extras, err := zenity.ListMultiple("What should "+name+" come with?",
+		[]string{extraSettings, extraPatches, extraNative},
+		zenity.Title("Venjector"), zenity.CheckList(), zenity.OKLabel("Create"))
+	if err != nil {
+		return nil
+	}
+
+	t := pluginTemplate{Name: name, Description: description}
+	for _, extra := range extras {
+		switch extra {
+		case extraSettings:
+			t.Settings = true
+		case extraPatches:
+			t.Patches = true
+		case extraNative:
+			t.Native = true
+		}
+	}
+
+	dir, err := newPlugin(t)
+	if err != nil {
+		return err
+	}
+	return openByPath(dir)
+}
@@ -0,0 +1,232 @@
+/*
+	Venjector: Copyright (C) 2023 tizu69
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+type injectionState string
+
+const (
+	stateVanilla   injectionState = "vanilla"   // not patched at all
+	stateVenjector injectionState = "venjector" // patched, using our build
+	stateVencord   injectionState = "vencord"   // patched, using some other Vencord, like the stock installer's
+	stateStale     injectionState = "stale"     // patched, but the build it points at is gone
+	stateUnknown   injectionState = "unknown"   // patched with something we can't read
+)
+
+func (s injectionState) String() string {
+	switch s {
+	case stateVanilla:
+		return "not injected"
+	case stateVenjector:
+		return "injected with Venjector"
+	case stateVencord:
+		return "injected with stock Vencord"
+	case stateStale:
+		return "injected, but the build is gone"
+	}
+	return "patched by something else"
+}
+
+// clientStatus is a client with what it's injected with.
+type clientStatus struct {
+	client
+	State   injectionState `json:"state"`
+	Target  string         `json:"target,omitempty"`  // the patcher or Vencord directory it loads
+	Profile string         `json:"profile,omitempty"` // whose build that is, if it's ours
+}
+
+// getStatus finds out what c is injected with.
+func getStatus(c client) clientStatus {
+	status := clientStatus{client: c, State: stateUnknown}
+
+	ours := profilePatcherPath
+	switch c.Kind {
+	case kindDiscord:
+		if _, err := os.Stat(filepath.Join(resourcesPath(c), originalAsar)); errors.Is(err, os.ErrNotExist) {
+			status.State = stateVanilla
+			return status
+		}
+
+		target, err := loaderTarget(resourcesPath(c))
+		if err != nil {
+			return status
+		}
+		status.Target = target
+	case kindVesktop:
+		ours = func(profile string) string { return profileVesktopBuildDir(c, profile) }
+		data, err := os.ReadFile(filepath.Join(c.Path, "settings.json"))
+		if err != nil {
+			return status
+		}
+
+		var settings map[string]interface{}
+		if json.Unmarshal(data, &settings) != nil {
+			return status
+		}
+		status.Target, _ = settings["vencordDir"].(string)
+		if status.Target == "" {
+			status.State = stateVanilla
+			return status
+		}
+	}
+
+	if _, err := os.Stat(status.Target); err != nil {
+		status.State = stateStale
+		return status
+	}
+
+	status.State = stateVencord
+	for _, profile := range listProfiles() {
+		if samePath(status.Target, ours(profile)) {
+			status.State, status.Profile = stateVenjector, profile
+		}
+	}
+	return status
+}
+
+func samePath(a, b string) bool {
+	a, b = filepath.Clean(a), filepath.Clean(b)
+	if runtime.GOOS == "windows" || runtime.GOOS == "darwin" {
+		return strings.EqualFold(a, b) // case-insensitive filesystems, usually
+	}
+	return a == b
+}
+
+// Loaders say require("path") or, from older installers, require(String.raw`path`)
+var requirePattern = regexp.MustCompile("require\\(\\s*(String\\.raw)?\\s*([\"'`])(.*?)[\"'`]\\s*\\)")
+
+// loaderTarget reads the path the loader in resources requires, from app.asar or an old installer's app directory.
+func loaderTarget(resources string) (string, error) {
+	index, err := os.ReadFile(filepath.Join(resources, "app", "index.js"))
+	if err != nil {
+		index, err = readAsarFile(filepath.Join(resources, "app.asar"), "index.js")
+	}
+	if err != nil {
+		return "", err
+	}
+
+	match := requirePattern.FindSubmatch(index)
+	if match == nil {
+		return "", errors.New("no require in loader")
+	}
+
+	path := string(match[3])
+	if len(match[1]) == 0 {
+		path = strings.ReplaceAll(path, `\\`, `\`)
+	}
+	return path, nil
+}
+
+// readAsarFile reads the file name from the root of the asar archive at path.
+func readAsarFile(path, name string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	// See loaderAsar for the layout
+	var sizes [4]uint32
+	if err := binary.Read(f, binary.LittleEndian, &sizes); err != nil {
+		return nil, err
+	}
+	if sizes[0] != 4 || sizes[3] > sizes[1] {
+		return nil, errors.New("not an asar archive")
+	}
+
+	headerJSON := make([]byte, sizes[3])
+	if _, err := io.ReadFull(f, headerJSON); err != nil {
+		return nil, err
+	}
+
+	header := struct {
+		Files map[string]struct {
+			Size   int64  `json:"size"`
+			Offset string `json:"offset"`
+		} `json:"files"`
+	}{}
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		return nil, err
+	}
+
+	file, ok := header.Files[name]
+	if !ok {
+		return nil, fmt.Errorf("no %s in %s", name, path)
+	}
+	offset, err := strconv.ParseInt(file.Offset, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, file.Size)
+	_, err = f.ReadAt(data, 8+int64(sizes[1])+offset)
+	return data, err
+}
+
+// statusSummary is a line per client for the main menu.
+func statusSummary() string {
+	lines := []string{}
+	for _, c := range findClients() {
+		status := getStatus(c)
+		line := c.Name + ": " + status.State.String()
+		if status.Profile != "" && status.Profile != currentProfile() {
+			line += " (profile " + status.Profile + ")"
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) == 0 {
+		return "No clients found"
+	}
+	return strings.Join(lines, "\n")
+}
+
+// statusCommand is 'venjector status'.
+func statusCommand() error {
+	statuses := []clientStatus{}
+	for _, c := range findClients() {
+		statuses = append(statuses, getStatus(c))
+	}
+
+	if cli.JSON {
+		if err := json.NewEncoder(os.Stdout).Encode(statuses); err != nil {
+			return wrapError(categoryUnknown, "Failed to print status", err)
+		}
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "CLIENT\tSTATUS\tPROFILE\tPATH\tLOADS")
+	for _, s := range statuses {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", s.Name, s.State, s.Profile, s.Path, s.Target)
+	}
+	return tw.Flush()
+}
@@ -18,13 +18,9 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
-	"io"
+	"errors"
 	"os"
-	"os/exec"
 	"path/filepath"
-	"strings"
 	"time"
 
 	"github.com/charmbracelet/log"
@@ -32,31 +28,18 @@ import (
 	cp "github.com/otiai10/copy"
 )
 
-func ensurePnpm() {
-	command := exec.Command("pnpm", "-v")
-
-	buf := new(bytes.Buffer)
-	command.Stdout = buf
-
-	err := command.Run()
-	fatalIfError("Failed to run PNPM, is it installed and in your PATH?", err)
-
-	ver := strings.ReplaceAll(buf.String(), "\n", "")
-	log.Info("Found PNPM", "version", ver)
-}
-
-func ensureGit() {
-	command := exec.Command("git", "-v")
-
-	buf := new(bytes.Buffer)
-	command.Stdout = buf
-
-	err := command.Run()
-	fatalIfError("Failed to run Git, is it installed and in your PATH?", err)
+// The rebuild happens in a staging checkout, so a failed or canceled rebuild leaves the last good build alone
+const (
+	repoDir    = "cord"
+	stagingDir = "cord.new"
+)
 
-	ver := strings.ReplaceAll(buf.String(), "\n", "")
-	log.Info("Found Git", "version", ver)
-}
+// Rebuild tasks commitRepo looks for. Skipping one of the others is fine, skipping these leaves a broken build.
+const (
+	taskPull    = "Downloading Vencord" // the first one
+	taskInstall = "Installing dependencies"
+	taskBuild   = "Building Vencord with plugins"
+)
 
 func userChoice() {
 	if cli.AutoChoice != -1 {
@@ -65,7 +48,7 @@ func userChoice() {
 		return
 	}
 
-	rootLocation := getConfigPath()
+	rootLocation := getBuildPath()
 	repoLocation := filepath.Join(rootLocation, "cord")
 	if _, err := os.Stat(rootLocation); os.IsNotExist(err) {
 		log.Info("No root directory, downloading Vencord repo")
@@ -81,15 +64,19 @@ func userChoice() {
 	const (
 		choiceRebuild = "Reload plugins"
 		choiceOpen    = "Open plugin directory"
+		choiceNew     = "Create a new plugin"
 		choiceInject  = "Install or uninstall Venjector"
 		choiceOpenWeb = "Manage downloaded plugins"
 		choiceUpdate  = "Update Vencord"
 		choiceVesktop = "Install Vesktop"
+		choiceLog     = "Show last log"
+		choiceProfile = "Manage profiles"
 		choiceAbout   = "About Venjector"
 	)
 
-	result, err := zenity.List("Welcome to Venjector, the plugin loader for the cutest client mod :3\nWhat do you wish to do today?",
-		[]string{choiceRebuild, choiceUpdate, choiceOpen, choiceOpenWeb, choiceInject, choiceVesktop, choiceAbout},
+	result, err := zenity.List("Welcome to Venjector, the plugin loader for the cutest client mod :3\n\n"+
+		"Profile: "+currentProfile()+"\n"+statusSummary()+"\n\nWhat do you wish to do today?",
+		[]string{choiceRebuild, choiceUpdate, choiceOpen, choiceNew, choiceOpenWeb, choiceInject, choiceVesktop, choiceLog, choiceProfile, choiceAbout},
 		zenity.Title("Venjector"), zenity.DisallowEmpty(), zenity.CancelLabel("Quit"))
 
 	switch err {
@@ -97,11 +84,13 @@ func userChoice() {
 		newProgress(1)
 		progress.Text("Have a nice day! :3")
 		time.Sleep(1 * time.Second) // This delay is unnecessary, but here to make the message readable
-		progress.Close()
+		closeProgress()
 
-		log.Fatal("Canceled by user")
+		log.Info("Canceled by user")
+		exit(exitOK)
+	case nil:
 	default:
-		fatalIfError("User select error", err)
+		fatal(wrapError(categoryPrerequisite, "User select error", err))
 	}
 
 	log.Info("Selected", "option", result)
@@ -110,6 +99,8 @@ func userChoice() {
 		process = 0
 	case choiceOpen:
 		process = 1
+	case choiceNew:
+		process = 7
 	case choiceInject:
 		process = 2
 	case choiceOpenWeb:
@@ -118,6 +109,10 @@ func userChoice() {
 		process = 0
 	case choiceVesktop:
 		process = 4
+	case choiceLog:
+		process = 5
+	case choiceProfile:
+		process = 6
 	case choiceAbout:
 		zenity.Info(`Thanks for using Venjector!
 
@@ -138,13 +133,15 @@ Venjector is licensed under the following license: GNU GPL (version 3)`)
 	}
 }
 
-func pullRepo() {
+func pullRepo() error {
 	log.Info("Pulling Vencord repo")
-	repoLocation := filepath.Join(getConfigPath(), "cord")
+	repoLocation := filepath.Join(getBuildPath(), stagingDir)
 
 	if _, err := os.Stat(repoLocation); err == nil {
-		log.Info("Deleting old Vencord repo, YOLO", "location", repoLocation)
-		os.RemoveAll(repoLocation)
+		log.Info("Deleting unfinished Vencord repo, YOLO", "location", repoLocation)
+		if err := os.RemoveAll(repoLocation); err != nil {
+			return wrapError(categoryFilesystem, "Failed to delete unfinished Vencord repo", err)
+		}
 
 		/* command := exec.Command("git", "pull")
 		command.Dir = repoLocation
@@ -155,79 +152,109 @@ func pullRepo() {
 		err := command.Run()
 		log.Info("Ran Git pull", "output", buf.String())
 
-		fatalIfError("Failed to run Git", err)
+		if err != nil {
+			return wrapError(categoryNetwork, "Failed to run Git", err)
+		}
 		log.Info("Successfully pulled Vencord repo")
-		return */
+		return nil */
 	}
 
 	abs, err := filepath.Abs(repoLocation)
-	fatalIfError("Failed to get absolute path", err)
+	if err != nil {
+		return wrapError(categoryFilesystem, "Failed to get absolute path", err)
+	}
 
-	command := exec.Command("git", "clone", repo, abs)
+	source := repo
+	if cli.Offline {
+		source = offlineRepoSource()
+		log.Info("Offline, cloning locally", "from", source)
+	}
 
-	buf := new(bytes.Buffer)
-	command.Stderr = buf
+	command := newCommand("git", "clone", "--progress", source, abs)
 
-	err = command.Run()
-	log.Info("Ran Git clone", "output", buf.String())
+	err = runLogged("git-clone", command, gitCloneProgress)
+	if err != nil && cli.Offline {
+		return wrapError(categoryPrerequisite, "Failed to clone "+source, err)
+	} else if err != nil {
+		return wrapError(categoryNetwork, "Failed to run Git", err)
+	}
 
-	fatalIfError("Failed to run Git", err)
-	log.Info("Successfully pulled Vencord repo")
+	if err := checkoutProfileRef(abs); err != nil {
+		return err
+	}
 
+	if source != repo {
+		// Vencord's updater asks git where it came from
+		command := newCommand("git", "remote", "set-url", "origin", repo)
+		command.Dir = abs
+		if err := runLogged("git-remote", command, nil); err != nil {
+			return wrapError(categoryBuild, "Failed to point the checkout at "+repo, err)
+		}
+	}
+
+	log.Info("Successfully pulled Vencord repo")
+	return nil
 }
 
-func pnpmInstall() {
+func pnpmInstall() error {
 	log.Info("Installing dependencies for Vencord")
-	repoLocation := filepath.Join(getConfigPath(), "cord")
-
-	command := exec.Command("pnpm", "install", "--frozen-lockfile")
-	command.Dir = repoLocation
-
-	buf := new(bytes.Buffer)
-	command.Stderr = buf
-
-	err := command.Run()
-	log.Info("Ran PNPM install", "output", buf.String())
-
-	fatalIfError("Failed to run PNPM", err)
+	repoLocation := filepath.Join(getBuildPath(), stagingDir)
+
+	err := runPnpm(runTask{
+		Name:     "pnpm-install",
+		Dir:      repoLocation,
+		Args:     []string{"install", "--frozen-lockfile"},
+		Offline:  cli.Offline,
+		Progress: pnpmInstallProgress,
+	})
+	if err != nil && cli.Offline {
+		return wrapError(categoryPrerequisite, "Failed to run PNPM offline, is the store populated?", err)
+	} else if err != nil {
+		return wrapError(categoryNetwork, "Failed to run PNPM", err)
+	}
 	log.Info("Successfully installed dependencies for Vencord")
+	return nil
 }
 
-func copyOverrides() {
+func copyOverrides() error {
 	log.Info("Copying overrides")
-	repoLocation := filepath.Join(getConfigPath(), "cord")
+	repoLocation := filepath.Join(getBuildPath(), stagingDir)
 	targetLocation := filepath.Join(repoLocation)
-	overridesLocation := filepath.Join(getConfigPath(), "overrides")
+	overridesLocation := filepath.Join(getProfilePath(), "overrides")
 	pluginLocation := filepath.Join(overridesLocation, "src", "userplugins")
 
 	if _, err := os.Stat(pluginLocation); err != nil {
 		log.Info("Creating plugin directory", "location", pluginLocation)
-		os.MkdirAll(pluginLocation, 0755)
+		if err := os.MkdirAll(pluginLocation, 0755); err != nil {
+			return wrapError(categoryFilesystem, "Failed to create plugin directory", err)
+		}
 	}
 
 	log.Info("Copying overrides recursively", "from", overridesLocation, "to", targetLocation)
 
 	err := cp.Copy(overridesLocation, targetLocation)
-	fatalIfError("Failed to copy overrides", err)
+	if err != nil {
+		return wrapError(categoryFilesystem, "Failed to copy overrides", err)
+	}
 
 	log.Info("Successfully copied overrides")
+	return nil
 }
 
-func downloadPlugs() {
+func downloadPlugs() error {
 	log.Info("Downloading remote plugins")
-	pluginLocation := filepath.Join(getConfigPath(), "cord", "src", "userplugins")
+	pluginLocation := filepath.Join(getBuildPath(), stagingDir, "src", "userplugins")
 
-	f, err := os.OpenFile(filepath.Join(getConfigPath(), "remote.json"), os.O_CREATE|os.O_RDONLY, 0644)
-	fatalIfError("Failed to open remote.json", err)
-
-	var data []string = []string{}
-	json.NewDecoder(f).Decode(&data)
-	// fatalIfError("Failed to decode remote.json", err)
+	data, err := readRemoteList()
+	if err != nil {
+		return err
+	}
 
 	for i, v := range data {
 		for j, w := range data {
 			if v == w && i != j {
 				data = remove(data, j)
+				addWarning("Removed duplicate plugin (" + v + ") from remote list")
 				zenity.Warning("Removed duplicate plugin (" + v + ") from remote list")
 				break
 			}
@@ -235,57 +262,115 @@ func downloadPlugs() {
 	}
 
 	for i, v := range data {
-		log.Info("Downloading remote plugin", "plugin", v)
-		downloadFile(filepath.Join(pluginLocation, "remotePlugin"+intToLetters(int32(i)), "index.tsx"), v)
+		cached := remoteCachePath(v)
+		if cli.Offline {
+			log.Info("Using cached remote plugin", "plugin", v)
+		} else {
+			log.Info("Downloading remote plugin", "plugin", v)
+			if err := downloadFile(cached, v); err != nil {
+				return err
+			}
+		}
+
+		target := filepath.Join(pluginLocation, "remotePlugin"+intToLetters(int32(i)), "index.tsx")
+		if err := cp.Copy(cached, target); err != nil {
+			return wrapError(categoryFilesystem, "Failed to copy remote plugin "+v, err)
+		}
 	}
 
 	log.Info("Successfully downloaded remote plugins")
+	return nil
 }
 
-func copyCore() {
+func copyCore() error {
 	log.Info("Copying core")
-	repoLocation := filepath.Join(getConfigPath(), "cord")
+	repoLocation := filepath.Join(getBuildPath(), stagingDir)
 	targetLocation := filepath.Join(repoLocation, "src")
 	pluginLocation := core
 
 	log.Info("Copying core recursively", "from", pluginLocation, "to", targetLocation)
 
-	os.MkdirAll(filepath.Join(targetLocation, "userplugins", "core"), 0755)
+	err := os.MkdirAll(filepath.Join(targetLocation, "userplugins", "core"), 0755)
+	if err != nil {
+		return wrapError(categoryFilesystem, "Failed to create core plugin directory", err)
+	}
 
 	files, err := getAllFilenames(&core)
-	fatalIfError("Failed to get files in core", err)
+	if err != nil {
+		return wrapError(categoryUnknown, "Failed to get files in core", err)
+	}
 
 	log.Info("Found some files in core", "files", files)
 
-	for from, to := range map[string]string{
-		"plugin.tsx":      "userplugins/core/index.tsx",
-		"pluginNative.ts": "userplugins/core/native.ts",
-		"tabUpdater.tsx":  "components/VencordSettings/UpdaterTab.tsx",
-		"tabPlugins.tsx":  "components/PluginSettings/index.tsx",
-	} {
+	// The core plugin gets where this Venjector, its data and the profile are, so it runs the right one
+	vars, err := loadReloadVars(repoLocation)
+	if err != nil {
+		return err
+	}
+	if err := writeCore(repoLocation, vars, func(string) bool { return true }); err != nil {
+		return err
+	}
+
+	log.Info("Successfully copied core plugins")
+	return nil
+}
+
+// coreFiles are core's files, and where they go in the checkout.
+var coreFiles = map[string]string{
+	"plugin.tsx":      "src/userplugins/core/index.tsx",
+	"pluginNative.ts": "src/userplugins/core/native.ts",
+}
+
+// writeCore writes core's files into the checkout in repoLocation and makes Venjector's edits to Vencord's, for
+// the paths (relative to the checkout) touched says yes to.
+func writeCore(repoLocation string, vars map[string]string, touched func(path string) bool) error {
+	for from, to := range coreFiles {
+		if !touched(to) {
+			continue
+		}
 		fileContent, err := core.ReadFile(filepath.Join("core", from))
-		fatalIfError("Failed to read core file", err)
+		if err != nil {
+			return wrapError(categoryUnknown, "Failed to read core file", err)
+		}
 
-		if cli.Visual {
+		if cli.Visual && progress != nil {
 			progress.Text("Copying core: " + from)
 		}
 
-		err = os.WriteFile(filepath.Join(targetLocation, to), fileContent, 0644)
-		fatalIfError("Failed to write core file", err)
+		target := filepath.Join(repoLocation, filepath.FromSlash(to))
+		if err := os.WriteFile(target, fileContent, 0644); err != nil {
+			return wrapError(categoryFilesystem, "Failed to write core file", err)
+		}
+		if err := insertReloadVars(target, vars); err != nil {
+			return wrapError(categoryFilesystem, "Failed to insert reload-time vars into core file", err)
+		}
 	}
 
-	log.Info("Successfully copied core plugins")
+	for _, p := range corePatches {
+		if !touched(p.File) {
+			continue
+		}
+		if cli.Visual && progress != nil {
+			progress.Text("Patching " + p.File)
+		}
+		if err := applyPatch(repoLocation, p); err != nil {
+			return err
+		}
+		if err := insertReloadVars(filepath.Join(repoLocation, filepath.FromSlash(p.File)), vars); err != nil {
+			return wrapError(categoryFilesystem, "Failed to insert reload-time vars into "+p.File, err)
+		}
+	}
+	return nil
 }
 
-func reloadVars() {
+func reloadVars() error {
 	log.Info("Inserting reload-time vars")
-	pluginLocation := filepath.Join(getConfigPath(), "cord", "src", "userplugins")
-
-	selfPath, err := os.Executable()
-	fatalIfError("Failed to get self path", err)
+	repoLocation := filepath.Join(getBuildPath(), stagingDir)
+	pluginLocation := filepath.Join(repoLocation, "src", "userplugins")
 
-	targets := map[string]string{
-		"$VENJECTOR-SELFPATH": selfPath,
+	vars, err := loadReloadVars(repoLocation)
+	if err != nil {
+		return err
 	}
 
 	err = filepath.Walk(pluginLocation, func(path string, info os.FileInfo, err error) error {
@@ -298,120 +383,92 @@ func reloadVars() {
 			progress.Text("Inserting reload-time vars: " + path)
 		}
 
-		contents, err := os.ReadFile(path)
-		if err != nil {
-			return err
-		}
-
-		for k, v := range targets {
-			contents = bytes.ReplaceAll(contents, []byte(k), []byte(v))
-		}
-
-		return os.WriteFile(path, contents, 0644)
+		return insertReloadVars(path, vars)
 	})
-	fatalIfError("Failed to insert reload-time vars", err)
+	if err != nil {
+		return wrapError(categoryFilesystem, "Failed to insert reload-time vars", err)
+	}
 
 	log.Info("Successfully inserted reload-time vars")
+	return nil
 }
 
-func pnpmTest() {
+func pnpmTest() error {
 	log.Info("Running tests")
-	repoLocation := filepath.Join(getConfigPath(), "cord")
-
-	command := exec.Command("pnpm", "test")
-	command.Dir = repoLocation
-
-	buf := new(bytes.Buffer)
-	command.Stderr = buf
-
-	/* err := */
-	command.Run()
-	log.Info("Ran PNPM test", "output", buf.String())
+	repoLocation := filepath.Join(getBuildPath(), stagingDir)
 
-	/* TODO: if _, ok := err.(*exec.ExitError); ok {
-		err := zenity.Error("Tests did not pass. If you feel experimental, click 'Continue anyway' to ignore test results.",
-			zenity.ExtraButton("Continue anyway"))
-		if err == zenity.ErrExtraButton {
-			log.Info("Continuing without tests")
-			return
-		}
+	err := runPnpm(runTask{
+		Name:     "pnpm-test",
+		Dir:      repoLocation,
+		Args:     []string{"test"},
+		Progress: countingProgress(pnpmScriptPattern, 6),
+	})
 
-		log.Fatal("Tests did not pass", "err", err)
-	} */
+	// Vencord's tests failing doesn't stop the build, like it never has
+	if err != nil {
+		log.Warn("Tests did not pass, building anyway", "err", err)
+		return nil
+	}
 
 	log.Info("Successfully ran tests")
+	return nil
 }
 
-func pnpmBuild() {
+func pnpmBuild() error {
 	log.Info("Building Vencord with plugins")
-	repoLocation := filepath.Join(getConfigPath(), "cord")
-
-	command := exec.Command("pnpm", "build")
-	command.Dir = repoLocation
-
-	buf := new(bytes.Buffer)
-	command.Stderr = buf
-
-	err := command.Run()
-	log.Info("Ran PNPM build", "output", buf.String())
+	repoLocation := filepath.Join(getBuildPath(), stagingDir)
 
-	fatalIfError("Failed to run PNPM", err)
+	err := runPnpm(runTask{
+		Name:     "pnpm-build",
+		Dir:      repoLocation,
+		Args:     []string{"build"},
+		Progress: countingProgress(esbuildPattern, 6),
+	})
+	if err != nil {
+		return wrapError(categoryBuild, "Failed to run PNPM", err)
+	}
 	log.Info("Successfully built Vencord with plugins")
+	return nil
 }
 
-func replaceDev() {
-	log.Info("Turning Vencord into production")
-	repoLocation := filepath.Join(getConfigPath(), "cord")
-
-	f, err := os.OpenFile(filepath.Join(repoLocation, "scripts", "runInstaller.mjs"), os.O_RDWR, 0644)
-	fatalIfError("Failed to open runInstaller.mjs", err)
-
-	lines, err := io.ReadAll(f)
-	fatalIfError("Failed to read runInstaller.mjs", err)
-
-	// lines = []byte(strings.Replace(string(lines), `VENCORD_USER_DATA_DIR: BASE_DIR,`, "", 1))
-	lines = []byte(strings.Replace(string(lines), `VENCORD_DEV_INSTALL: "1"`, "", 1))
-
-	err = os.WriteFile(filepath.Join(repoLocation, "scripts", "runInstaller.mjs"), lines, 0644)
-	fatalIfError("Failed to write runInstaller.mjs", err)
-}
-
-func injecc() {
-	log.Info("Injecting Vencord with Venjector")
-	repoLocation := filepath.Join(getConfigPath(), "cord")
-
-	command := exec.Command("pnpm", "inject")
-	command.Dir = repoLocation
-
-	buf := new(bytes.Buffer)
-	command.Stderr = buf
-
-	err := command.Run()
-	log.Info("Ran PNPM inject", "output", buf.String())
-
-	fatalIfError("Failed to run PNPM", err)
-	log.Info("Successfully injected Vencord with Venjector")
-}
-
-func injeccVesktop() {
-	log.Info("Injecting Vencord with Venjector")
-	repoLocation := filepath.Join(getConfigPath(), "cord", "dist")
-	vesktopLocation := getVesktopPath()
-
-	data, err := os.ReadFile(filepath.Join(vesktopLocation, "settings.json"))
-	fatalIfError("Failed to read settings.json", err)
-
-	var objmap map[string]interface{}
-	err = json.Unmarshal(data, &objmap)
-	fatalIfError("Failed to unmarshal settings.json", err)
+// commitRepo replaces the last good build with the freshly built staging checkout.
+// A new build that's missing something never replaces the last good one, the run aborts and throws it away instead.
+func commitRepo() error {
+	log.Info("Replacing the previous build")
+	repoLocation := filepath.Join(getBuildPath(), repoDir)
+	stagingLocation := filepath.Join(getBuildPath(), stagingDir)
+	oldLocation := filepath.Join(getBuildPath(), repoDir+".old")
+
+	for i := len(result.Steps) - 1; i >= 0; i-- {
+		step := result.Steps[i]
+		if step.Skipped && (step.Task == taskPull || step.Task == taskInstall || step.Task == taskBuild) {
+			return wrapUnskippable(categoryBuild, "Not replacing the previous build, '"+step.Task+"' was skipped",
+				errors.New(step.Error))
+		}
+		if step.Task == taskPull {
+			break // that's where this rebuild started
+		}
+	}
+	if _, err := os.Stat(filepath.Join(stagingLocation, "dist", "patcher.js")); err != nil {
+		return wrapUnskippable(categoryBuild, "Not replacing the previous build, the new one is incomplete", err)
+	}
 
-	objmap["vencordDir"] = repoLocation
+	os.RemoveAll(oldLocation)
+	if _, err := os.Stat(repoLocation); err == nil {
+		if err := os.Rename(repoLocation, oldLocation); err != nil {
+			return wrapError(categoryFilesystem, "Failed to move the previous build away", err)
+		}
+	}
 
-	data, err = json.Marshal(objmap)
-	fatalIfError("Failed to marshal settings.json", err)
+	if err := os.Rename(stagingLocation, repoLocation); err != nil {
+		os.Rename(oldLocation, repoLocation)
+		return wrapError(categoryFilesystem, "Failed to move the new build in place", err)
+	}
 
-	err = os.WriteFile(filepath.Join(vesktopLocation, "settings.json"), data, 0644)
-	fatalIfError("Failed to write settings.json", err)
+	if err := os.RemoveAll(oldLocation); err != nil {
+		log.Warn("Failed to remove the previous build", "location", oldLocation, "err", err)
+	}
 
-	log.Info("Successfully injected Vencord with Venjector")
+	log.Info("Successfully replaced the previous build")
+	return nil
 }
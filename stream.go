@@ -0,0 +1,157 @@
+/*
+	Venjector: Copyright (C) 2023 tizu69
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Every step is split into this many ticks, so subprocesses can report how far along they are
+const progressScale = 100
+
+var (
+	currentStep = 0
+	currentTask = ""
+)
+
+// lineProgress tells how far along a step is, in percent, from a line of its output.
+type lineProgress func(line string) (percent int, ok bool)
+
+var (
+	ansiPattern        = regexp.MustCompile(`\x1b\[[0-9;?]*[a-zA-Z]`)
+	gitPattern         = regexp.MustCompile(`^(Receiving objects|Resolving deltas):\s+(\d+)%`)
+	pnpmInstallPattern = regexp.MustCompile(`Progress: resolved (\d+), reused \d+, downloaded \d+, added (\d+)`)
+	esbuildPattern     = regexp.MustCompile(`Done in \d+`)
+	pnpmScriptPattern  = regexp.MustCompile(`^> \S+ \S+`)
+)
+
+// gitCloneProgress follows 'git clone --progress'. Receiving objects is most of the work, so it gets 80%.
+func gitCloneProgress(line string) (int, bool) {
+	m := gitPattern.FindStringSubmatch(line)
+	if m == nil {
+		return 0, false
+	}
+
+	percent, _ := strconv.Atoi(m[2])
+	if m[1] == "Receiving objects" {
+		return percent * 80 / 100, true
+	}
+	return 80 + percent*20/100, true
+}
+
+// pnpmInstallProgress follows pnpm's 'Progress: resolved X, ..., added Y' lines.
+func pnpmInstallProgress(line string) (int, bool) {
+	m := pnpmInstallPattern.FindStringSubmatch(line)
+	if m == nil {
+		return 0, false
+	}
+
+	resolved, _ := strconv.Atoi(m[1])
+	added, _ := strconv.Atoi(m[2])
+	if resolved == 0 {
+		return 0, false
+	}
+	return added * 100 / resolved, true
+}
+
+// countingProgress counts lines matching pattern, expecting about expected of them.
+// Good enough for esbuild's 'Done in' lines and the '> vencord@x.y.z script' headers pnpm prints.
+func countingProgress(pattern *regexp.Regexp, expected int) lineProgress {
+	seen := 0
+	return func(line string) (int, bool) {
+		if !pattern.MatchString(line) {
+			return 0, false
+		}
+		seen++
+		return seen * 100 / (expected + 1), true
+	}
+}
+
+// lineWriter splits whatever is written to it into lines. Lines ending in '\r' (git's progress does that)
+// are passed on too, but marked as not final.
+type lineWriter struct {
+	buf    []byte
+	onLine func(line string, final bool)
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexAny(w.buf, "\r\n")
+		if i < 0 {
+			break
+		}
+
+		line, final := string(w.buf[:i]), w.buf[i] == '\n'
+		w.buf = w.buf[i+1:]
+		if len(line) != 0 {
+			w.onLine(line, final)
+		}
+	}
+	return len(p), nil
+}
+
+func (w *lineWriter) Flush() {
+	if len(w.buf) != 0 {
+		w.onLine(string(w.buf), true)
+		w.buf = nil
+	}
+}
+
+// newStreamer returns a function that shows a subprocess's output line by line: on the terminal, and with
+// --visual in the progress dialog. If parse is given, it also moves the progress bar within the current step.
+// Stdout and stderr are written from different goroutines, so it locks.
+func newStreamer(name string, parse lineProgress) func(line string, final bool) {
+	mu := new(sync.Mutex)
+	lastUpdate := time.Time{}
+	lastPercent := -1
+
+	return func(line string, final bool) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		line = ansiPattern.ReplaceAllString(line, "")
+		if final {
+			fmt.Fprintf(os.Stderr, "%s | %s\n", name, line)
+		}
+
+		if progress == nil {
+			return
+		}
+
+		if parse != nil {
+			if percent, ok := parse(line); ok && percent != lastPercent {
+				lastPercent = percent
+				progress.Value(currentStep*progressScale + min(percent, progressScale-1))
+				emitProgress(min(percent, progressScale-1))
+			}
+		}
+
+		// Don't flood the dialog, it has to keep up
+		if cli.Visual && time.Since(lastUpdate) > 100*time.Millisecond {
+			lastUpdate = time.Now()
+			progress.Text(currentTask + ": " + line)
+		}
+	}
+}
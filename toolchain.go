@@ -0,0 +1,532 @@
+/*
+	Venjector: Copyright (C) 2023 tizu69
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/charmbracelet/log"
+)
+
+// toolchain is a private Node.js and pnpm, provisioned into the data directory by provisionToolchain.
+type toolchain struct {
+	NodeVersion string `json:"nodeVersion"`
+	Node        string `json:"node"` // the node binary
+	PnpmVersion string `json:"pnpmVersion"`
+	Pnpm        string `json:"pnpm"` // pnpm's entry point, run with Node
+}
+
+func getToolchainPath() string {
+	return filepath.Join(getDataPath(), "toolchain")
+}
+
+// loadToolchain returns the last provisioned toolchain.
+func loadToolchain() (*toolchain, error) {
+	data, err := os.ReadFile(filepath.Join(getToolchainPath(), "current.json"))
+	if err != nil {
+		return nil, wrapError(categoryPrerequisite, "No managed toolchain yet, reload plugins first", err)
+	}
+
+	tc := &toolchain{}
+	if err := json.Unmarshal(data, tc); err != nil {
+		return nil, wrapError(categoryFilesystem, "Failed to read managed toolchain", err)
+	}
+
+	for _, path := range []*string{&tc.Node, &tc.Pnpm} {
+		if !filepath.IsAbs(*path) {
+			*path, _ = filepath.Abs(filepath.Join(getToolchainPath(), *path))
+		}
+	}
+	return tc, nil
+}
+
+// saveToolchain makes tc the current toolchain. Its paths are stored relative to the toolchain directory, so
+// the data directory can move.
+func saveToolchain(tc *toolchain) error {
+	stored := *tc
+	for _, path := range []*string{&stored.Node, &stored.Pnpm} {
+		if rel, err := filepath.Rel(getToolchainPath(), *path); err == nil && !strings.HasPrefix(rel, "..") {
+			*path = rel
+		}
+	}
+
+	data, err := json.MarshalIndent(stored, "", "\t")
+	if err != nil {
+		return wrapError(categoryUnknown, "Failed to marshal toolchain", err)
+	}
+	err = os.WriteFile(filepath.Join(getToolchainPath(), "current.json"), data, 0644)
+	return wrapError(categoryFilesystem, "Failed to write toolchain", err)
+}
+
+// writePnpmShim writes a 'pnpm' that runs the managed one into the toolchain's shims directory, and returns it.
+// It's written every time, as the data directory, and so the toolchain, can move.
+func writePnpmShim(tc *toolchain) (string, error) {
+	dir := filepath.Join(getToolchainPath(), "shims")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", wrapError(categoryFilesystem, "Failed to create "+dir, err)
+	}
+
+	name, shim := "pnpm", fmt.Sprintf("#!/bin/sh\nexec '%s' '%s' \"$@\"\n", shellQuote(tc.Node), shellQuote(tc.Pnpm))
+	if runtime.GOOS == "windows" {
+		name, shim = "pnpm.cmd", fmt.Sprintf("@\"%s\" \"%s\" %%*\r\n", tc.Node, tc.Pnpm)
+	}
+
+	err := os.WriteFile(filepath.Join(dir, name), []byte(shim), 0755)
+	return dir, wrapError(categoryFilesystem, "Failed to write pnpm shim", err)
+}
+
+// shellQuote escapes s for single quotes in sh.
+func shellQuote(s string) string {
+	return strings.ReplaceAll(s, "'", `'\''`)
+}
+
+// provisionToolchain makes sure the toolchain the freshly downloaded Vencord wants is there.
+func provisionToolchain() error {
+	if !usesManagedToolchain() {
+		return nil
+	} else if cli.Offline && cli.NodeTarball == "" {
+		// checkOffline made sure there is one
+		tc, err := loadToolchain()
+		if err == nil {
+			log.Info("Offline, using the managed toolchain we have", "node", tc.NodeVersion, "pnpm", tc.PnpmVersion)
+		}
+		return err
+	}
+
+	pkg, err := readVencordPackage(stagingDir)
+	if err != nil {
+		return wrapError(categoryFilesystem, "Failed to read Vencord's package.json", err)
+	}
+
+	name, pnpmVersion, ok := strings.Cut(pkg.PackageManager, "@")
+	if !ok || name != "pnpm" {
+		return wrapError(categoryPrerequisite, "Vencord doesn't pin a pnpm version",
+			fmt.Errorf("packageManager is %q", pkg.PackageManager))
+	}
+	pnpmVersion, _, _ = strings.Cut(pnpmVersion, "+")
+
+	if err := os.MkdirAll(getToolchainPath(), 0755); err != nil {
+		return wrapError(categoryFilesystem, "Failed to create toolchain directory", err)
+	}
+
+	tc := &toolchain{PnpmVersion: pnpmVersion}
+	if tc.NodeVersion, tc.Node, err = provisionNode(pkg.Engines["node"]); err != nil {
+		return err
+	}
+	if tc.Pnpm, err = provisionPnpm(pnpmVersion); err != nil {
+		return err
+	}
+
+	if err := saveToolchain(tc); err != nil {
+		return err
+	}
+
+	log.Info("Using managed toolchain", "node", tc.NodeVersion, "pnpm", tc.PnpmVersion)
+	return nil
+}
+
+// nodePlatform returns Node.js' name for this OS and architecture, and the archive extension its builds use.
+func nodePlatform() (string, string, error) {
+	arch, ok := map[string]string{"amd64": "x64", "arm64": "arm64", "386": "x86", "arm": "armv7l"}[runtime.GOARCH]
+	if !ok {
+		return "", "", errors.New("unsupported architecture " + runtime.GOARCH)
+	}
+
+	switch runtime.GOOS {
+	case "linux", "darwin":
+		return runtime.GOOS + "-" + arch, ".tar.gz", nil
+	case "windows":
+		return "win-" + arch, ".zip", nil
+	}
+	return "", "", errors.New("unsupported OS " + runtime.GOOS)
+}
+
+// resolveNodeVersion picks the newest LTS release from the mirror that satisfies required.
+func resolveNodeVersion(required string) (string, error) {
+	if cli.NodeVersion != "" {
+		return strings.TrimPrefix(cli.NodeVersion, "v"), nil
+	}
+
+	resp, err := httpGet(cli.NodeMirror + "/index.json")
+	if err != nil {
+		return "", wrapError(categoryNetwork, "Failed to get Node.js releases", err)
+	}
+	defer resp.Body.Close()
+
+	var releases []struct {
+		Version string `json:"version"`
+		LTS     any    `json:"lts"` // false, or the codename
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&releases); err != nil {
+		return "", wrapError(categoryNetwork, "Failed to read Node.js releases", err)
+	}
+
+	for _, release := range releases { // newest first
+		if lts, isBool := release.LTS.(bool); release.LTS == nil || (isBool && !lts) {
+			continue
+		}
+		v, ok := parseVersion(release.Version)
+		if ok && (required == "" || satisfies(v, required)) {
+			return v.String(), nil
+		}
+	}
+	return "", wrapError(categoryPrerequisite, "No Node.js LTS release satisfies "+required, os.ErrNotExist)
+}
+
+func provisionNode(required string) (string, string, error) {
+	platform, ext, err := nodePlatform()
+	if err != nil {
+		return "", "", wrapError(categoryPrerequisite, "Can't provision Node.js", err)
+	}
+
+	ver := ""
+	name := ""
+	archive := cli.NodeTarball
+	if archive != "" {
+		// node-v20.11.0-linux-x64.tar.gz
+		name = strings.TrimSuffix(strings.TrimSuffix(filepath.Base(archive), ".tar.gz"), ".zip")
+		v, _ := parseVersion(name)
+		ver = v.String()
+	} else {
+		if ver, err = resolveNodeVersion(required); err != nil {
+			return "", "", err
+		}
+		name = "node-v" + ver + "-" + platform
+	}
+
+	dir := filepath.Join(getToolchainPath(), name)
+	binary := filepath.Join(dir, "bin", "node")
+	if runtime.GOOS == "windows" {
+		binary = filepath.Join(dir, "node.exe")
+	}
+
+	if _, err := os.Stat(binary); err == nil {
+		log.Info("Node.js already provisioned", "version", ver)
+		return ver, binary, nil
+	}
+
+	// Node.js publishes checksums for every file of a release
+	sums := map[string]string{}
+	var sumsReader io.ReadCloser
+	if archive != "" {
+		sumsReader, err = os.Open(filepath.Join(filepath.Dir(archive), "SHASUMS256.txt"))
+	} else {
+		var resp *http.Response
+		resp, err = httpGet(fmt.Sprintf("%s/v%s/SHASUMS256.txt", cli.NodeMirror, ver))
+		if err == nil {
+			sumsReader = resp.Body
+		}
+	}
+	if err == nil {
+		scanner := bufio.NewScanner(sumsReader)
+		for scanner.Scan() {
+			if sum, file, ok := strings.Cut(scanner.Text(), "  "); ok {
+				sums[file] = sum
+			}
+		}
+		sumsReader.Close()
+	} else if archive == "" {
+		return "", "", wrapError(categoryNetwork, "Failed to get Node.js checksums", err)
+	}
+
+	if archive == "" {
+		archive = filepath.Join(getToolchainPath(), name+ext)
+		err := downloadFile(archive, fmt.Sprintf("%s/v%s/%s%s", cli.NodeMirror, ver, name, ext))
+		if err != nil {
+			return "", "", err
+		}
+		defer os.Remove(archive)
+	}
+
+	want, ok := sums[name+ext]
+	if cli.NodeTarball != "" && cli.NodeSha256 != "" {
+		want, ok = strings.ToLower(cli.NodeSha256), true
+	}
+	if !ok && cli.NodeTarball != "" {
+		return "", "", wrapError(categoryPrerequisite, "No checksum for "+filepath.Base(archive)+
+			", pass --node-sha256 or put Node.js' SHASUMS256.txt next to it", os.ErrNotExist)
+	} else if !ok {
+		return "", "", wrapError(categoryNetwork, "No checksum for "+name+ext, os.ErrNotExist)
+	}
+	if err := verifyFile(archive, sha256.New(), want, hex.EncodeToString); err != nil {
+		return "", "", err
+	}
+
+	log.Info("Extracting Node.js", "version", ver, "to", dir)
+	if err := extractArchive(archive, getToolchainPath(), true); err != nil {
+		return "", "", err
+	}
+	return ver, binary, nil
+}
+
+func provisionPnpm(ver string) (string, error) {
+	dir := filepath.Join(getToolchainPath(), "pnpm-"+ver)
+	entry := filepath.Join(dir, "package", "bin", "pnpm.cjs")
+
+	if _, err := os.Stat(entry); err == nil {
+		log.Info("pnpm already provisioned", "version", ver)
+		return entry, nil
+	}
+
+	archive, integrity := cli.PnpmTarball, cli.PnpmIntegrity
+	if archive != "" && integrity == "" {
+		return "", wrapError(categoryPrerequisite, "No checksum for "+filepath.Base(archive)+", pass --pnpm-integrity",
+			os.ErrNotExist)
+	} else if archive == "" {
+		resp, err := httpGet(fmt.Sprintf("%s/pnpm/%s", cli.NpmRegistry, ver))
+		if err != nil {
+			return "", wrapError(categoryNetwork, "Failed to get pnpm "+ver, err)
+		}
+		defer resp.Body.Close()
+
+		var meta struct {
+			Dist struct {
+				Tarball   string `json:"tarball"`
+				Integrity string `json:"integrity"`
+			} `json:"dist"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&meta); err != nil {
+			return "", wrapError(categoryNetwork, "Failed to read pnpm "+ver, err)
+		}
+
+		archive, integrity = filepath.Join(getToolchainPath(), "pnpm-"+ver+".tgz"), meta.Dist.Integrity
+		if err := downloadFile(archive, meta.Dist.Tarball); err != nil {
+			return "", err
+		}
+		defer os.Remove(archive)
+	}
+
+	// The npm registry uses Subresource Integrity, "sha512-<base64>"
+	algo, sum, _ := strings.Cut(integrity, "-")
+	if algo != "sha512" {
+		return "", wrapError(categoryPrerequisite, "Unexpected pnpm integrity, it should start with sha512-",
+			errors.New(integrity))
+	}
+	if err := verifyFile(archive, sha512.New(), sum, base64.StdEncoding.EncodeToString); err != nil {
+		return "", err
+	}
+
+	log.Info("Extracting pnpm", "version", ver, "to", dir)
+	if err := extractArchive(archive, dir, false); err != nil { // npm packages don't have symlinks
+		return "", err
+	}
+	return entry, nil
+}
+
+// verifyFile hashes path and compares it to want, which is in whatever encoding encode produces.
+func verifyFile(path string, h hash.Hash, want string, encode func([]byte) string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return wrapError(categoryFilesystem, "Failed to open "+path, err)
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(h, f); err != nil {
+		return wrapError(categoryFilesystem, "Failed to read "+path, err)
+	}
+
+	if got := encode(h.Sum(nil)); got != want {
+		return wrapError(categoryNetwork, "Checksum mismatch for "+filepath.Base(path),
+			fmt.Errorf("expected %s, got %s", want, got))
+	}
+	log.Info("Verified", "file", filepath.Base(path))
+	return nil
+}
+
+// safeJoin joins name onto dir, refusing anything that would end up outside of it.
+func safeJoin(dir, name string) (string, error) {
+	path := filepath.Join(dir, name)
+	if !strings.HasPrefix(path, filepath.Clean(dir)+string(os.PathSeparator)) {
+		return "", fmt.Errorf("%s escapes %s", name, dir)
+	}
+	return path, nil
+}
+
+// withinDir tells if path is dir or something in it.
+func withinDir(dir, path string) bool {
+	return path == dir || strings.HasPrefix(path, dir+string(os.PathSeparator))
+}
+
+// checkResolved makes sure path is still in dir once the symlinks on the way to it are followed, so an archive
+// can't make a link first and then write through it. path itself may not exist yet.
+func checkResolved(dir, path string) error {
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return err
+	}
+
+	existing := path
+	for {
+		if _, err := os.Lstat(existing); err == nil || existing == filepath.Dir(existing) {
+			break
+		}
+		existing = filepath.Dir(existing)
+	}
+	if existing == path {
+		existing = filepath.Dir(path) // a link at path itself gets replaced, not followed
+	}
+
+	resolved, err := filepath.EvalSymlinks(existing)
+	if err != nil {
+		return err
+	}
+	if !withinDir(root, resolved) {
+		return fmt.Errorf("%s leads out of %s, through %s", path, dir, resolved)
+	}
+	return nil
+}
+
+// extractArchive extracts a .tar.gz, .tgz or .zip into dir. Symlinks are only allowed with symlinks, and only if
+// they point somewhere in dir; Node.js has a few, our bundles never do.
+func extractArchive(archive, dir string, symlinks bool) error {
+	err := os.MkdirAll(dir, 0755)
+	if err == nil && strings.HasSuffix(archive, ".zip") {
+		err = extractZip(archive, dir)
+	} else if err == nil {
+		err = extractTarGz(archive, dir, symlinks)
+	}
+	return wrapError(categoryFilesystem, "Failed to extract "+filepath.Base(archive), err)
+}
+
+func extractTarGz(archive, dir string, symlinks bool) error {
+	f, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		path, err := safeJoin(dir, header.Name)
+		if err != nil {
+			return err
+		}
+		if err := checkResolved(dir, path); err != nil {
+			return err
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(path, 0755)
+		case tar.TypeSymlink:
+			if !symlinks {
+				return fmt.Errorf("%s is a symlink, there shouldn't be any", header.Name)
+			}
+			target := filepath.Join(filepath.Dir(path), header.Linkname)
+			if filepath.IsAbs(header.Linkname) || !withinDir(filepath.Clean(dir), target) {
+				return fmt.Errorf("%s links to %s, outside of %s", header.Name, header.Linkname, dir)
+			}
+			os.MkdirAll(filepath.Dir(path), 0755)
+			os.Remove(path)
+			err = os.Symlink(header.Linkname, path)
+		case tar.TypeReg:
+			err = writeFileFrom(path, tr, header.FileInfo().Mode())
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func extractZip(archive, dir string) error {
+	zr, err := zip.OpenReader(archive)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	for _, file := range zr.File {
+		path, err := safeJoin(dir, file.Name)
+		if err != nil {
+			return err
+		}
+		if err := checkResolved(dir, path); err != nil {
+			return err
+		}
+
+		if file.FileInfo().IsDir() {
+			if err := os.MkdirAll(path, 0755); err != nil {
+				return err
+			}
+			continue
+		}
+
+		r, err := file.Open()
+		if err != nil {
+			return err
+		}
+		err = writeFileFrom(path, r, file.Mode())
+		r.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeFileFrom(path string, r io.Reader, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	// Replace a symlink there instead of writing to wherever it points
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+
+	out, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode.Perm())
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	_, err = io.Copy(out, r)
+	return err
+}
@@ -0,0 +1,141 @@
+/*
+	Venjector: Copyright (C) 2023 tizu69
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// tarEntry is a file, or a symlink if link is set.
+type tarEntry struct {
+	name, body, link string
+}
+
+// writeTarGz makes a .tar.gz with entries in a temporary directory.
+func writeTarGz(t *testing.T, entries ...tarEntry) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "archive.tar.gz")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	for _, e := range entries {
+		header := &tar.Header{Name: e.name, Mode: 0644, Size: int64(len(e.body)), Typeflag: tar.TypeReg}
+		if e.link != "" {
+			header.Typeflag, header.Linkname, header.Size = tar.TypeSymlink, e.link, 0
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestExtractSymlinksInside(t *testing.T) {
+	dir := t.TempDir()
+	archive := writeTarGz(t,
+		tarEntry{name: "node/lib/npm-cli.js", body: "npm"},
+		tarEntry{name: "node/bin/npm", link: "../lib/npm-cli.js"},
+	)
+
+	if err := extractArchive(archive, dir, true); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "node", "bin", "npm"))
+	if err != nil || string(data) != "npm" {
+		t.Fatalf("link doesn't work: %q, %v", data, err)
+	}
+}
+
+func TestExtractRejectsSymlinks(t *testing.T) {
+	dir := t.TempDir()
+	archive := writeTarGz(t, tarEntry{name: "overrides", link: "somewhere"})
+
+	if err := extractArchive(archive, dir, false); err == nil {
+		t.Fatal("extracted a symlink where there shouldn't be any")
+	}
+}
+
+func TestExtractRejectsEscapingSymlinks(t *testing.T) {
+	for _, link := range []string{"/etc", "../outside", "a/../../outside"} {
+		dir := t.TempDir()
+		archive := writeTarGz(t, tarEntry{name: "evil", link: link})
+
+		if err := extractArchive(archive, dir, true); err == nil {
+			t.Errorf("extracted a link to %s", link)
+		}
+	}
+}
+
+func TestExtractDoesNotWriteThroughSymlinks(t *testing.T) {
+	outside := t.TempDir()
+	dir := t.TempDir()
+
+	// A link that's already there, like one from an earlier extraction
+	if err := os.Symlink(outside, filepath.Join(dir, "evil")); err != nil {
+		t.Fatal(err)
+	}
+	archive := writeTarGz(t, tarEntry{name: "evil/pwned", body: "pwned"})
+
+	if err := extractArchive(archive, dir, true); err == nil {
+		t.Error("wrote through a symlink")
+	}
+	if _, err := os.Stat(filepath.Join(outside, "pwned")); err == nil {
+		t.Error("file ended up outside of the directory")
+	}
+}
+
+func TestExtractReplacesSymlinkedFiles(t *testing.T) {
+	outside := filepath.Join(t.TempDir(), "target")
+	if err := os.WriteFile(outside, []byte("original"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := os.Symlink(outside, filepath.Join(dir, "file")); err != nil {
+		t.Fatal(err)
+	}
+	archive := writeTarGz(t, tarEntry{name: "file", body: "new"})
+
+	if err := extractArchive(archive, dir, true); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(outside); string(data) != "original" {
+		t.Errorf("wrote through the link, target is now %q", data)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "file")); string(data) != "new" {
+		t.Errorf("file is %q, want new", data)
+	}
+}
@@ -19,6 +19,8 @@ package main
 
 import (
 	"embed"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
@@ -27,9 +29,11 @@ import (
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"sync/atomic"
 	"time"
 
 	"github.com/charmbracelet/log"
+
 	"github.com/ncruces/zenity"
 )
 
@@ -37,78 +41,40 @@ const (
 	repo = "https://github.com/Vendicated/Vencord"
 )
 
-func fatalIfError(task string, err error) {
-	if err != nil {
-		zenity.Error(fmt.Sprintf("ERROR: %s - %s", task, err.Error()))
-		log.Fatal(task, "err", err)
-	}
-}
+func setVal(val int, task string, run func() error) {
+	checkCanceled()
 
-func setVal(val int, task string, run func()) {
-	progress.Value(val)
+	currentStep, currentTask = val, task
+	progress.Value(val * progressScale)
 	progress.Text(task + "..")
-	run()
-	time.Sleep(250 * time.Millisecond) // idk why, but without a delay this crashed Zenity on my end.
-	if progress.MaxValue() == val+1 {
-		time.Sleep(750 * time.Millisecond) // let the user read, duh :3
-		progress.Close()
-	}
-}
-
-var (
-	configLnx = filepath.Join(os.Getenv("HOME"), ".config/Venjector")
-	configMac = filepath.Join(os.Getenv("HOME"), "Library/Application Support/Venjector")
-	configWin = filepath.Join(os.Getenv("LOCALAPPDATA"), "Venjector")
-)
-
-var (
-	configLnxLocal = "./venjectorConfig"
-	configMacLocal = "./venjectorConfig"
-	configWinLocal = ".\\venjectorConfig"
-)
-
-func getConfigPath() string {
-	switch runtime.GOOS {
-	case "linux":
-		if cli.LocalData {
-			return os.ExpandEnv(configLnxLocal)
+	emitProgress(0)
+
+	start := time.Now()
+	result.Steps = append(result.Steps, stepResult{Task: task})
+	step := &result.Steps[len(result.Steps)-1]
+
+	for {
+		step.Attempts++
+		err := run()
+		step.Duration = time.Since(start).Milliseconds()
+		if err == nil {
+			step.Error = ""
+			break
 		}
-		return os.ExpandEnv(configLnx)
-	case "darwin":
-		if cli.LocalData {
-			return os.ExpandEnv(configMacLocal)
-		}
-		return os.ExpandEnv(configMac)
-	case "windows":
-		if cli.LocalData {
-			return os.ExpandEnv(configWinLocal)
+
+		step.Error = err.Error()
+		if !handleError(task, err, step.Attempts) {
+			step.Skipped = true
+			addError(err)
+			break
 		}
-		return os.ExpandEnv(configWin)
+		progress.Text(task + "..")
 	}
-
-	log.Fatal("Unsupported OS")
-	return ""
-}
-
-var (
-	vesktopConfigLnx = filepath.Join(os.Getenv("HOME"), ".config/VencordDesktop/VencordDesktop")
-	vesktopConfigMac = filepath.Join(os.Getenv("HOME"), "Library/Application Support/VencordDesktop/VencordDesktop")
-	vesktopConfigWin = filepath.Join(os.Getenv("LOCALAPPDATA"), "VencordDesktop\\VencordDesktop")
-)
-
-func getVesktopPath() string {
-
-	switch runtime.GOOS {
-	case "linux":
-		return os.ExpandEnv(vesktopConfigLnx)
-	case "darwin":
-		return os.ExpandEnv(vesktopConfigMac)
-	case "windows":
-		return os.ExpandEnv(vesktopConfigWin)
+	time.Sleep(250 * time.Millisecond) // idk why, but without a delay this crashed Zenity on my end.
+	if progress.MaxValue() == (val+1)*progressScale {
+		time.Sleep(750 * time.Millisecond) // let the user read, duh :3
+		closeProgress()
 	}
-
-	log.Fatal("Unsupported OS")
-	return ""
 }
 
 // https://stackoverflow.com/a/30708914
@@ -126,7 +92,7 @@ func isEmpty(name string) (bool, error) {
 	return false, err // Either not empty or error, suits both cases
 }
 
-func openByPath(path string) {
+func openByPath(path string) error {
 	var err error
 
 	switch runtime.GOOS {
@@ -139,20 +105,49 @@ func openByPath(path string) {
 	default:
 		err = fmt.Errorf("unsupported platform")
 	}
-	fatalIfError("Failed to open "+path, err)
+	return wrapError(categoryPrerequisite, "Failed to open "+path, err)
 }
 
 func newProgress(max int) {
 	p, err := zenity.Progress(
 		zenity.Title("Venjector"),
 		zenity.AutoClose(),
-		zenity.NoCancel(),
-		zenity.MaxValue(max+1),
+		zenity.MaxValue((max+1)*progressScale),
 		zenity.TimeRemaining(),
 	)
-	fatalIfError("Failed to open the GUI, install one of 'zenity, matedialog, qarma' on Linux or 'osascript' on macOS, then try again", err)
+	if err != nil {
+		fatal(wrapError(categoryPrerequisite, "Failed to open the GUI, install one of 'zenity, matedialog, qarma' on Linux or 'osascript' on macOS, then try again", err))
+	}
+
+	// If the dialog goes away without us closing it, the user clicked cancel
+	closed := new(atomic.Bool)
+	go func() {
+		<-p.Done()
+		if !closed.Load() {
+			log.Warn("Canceled from the progress dialog")
+			cancelRun()
+		}
+	}()
+
 	time.Sleep(1 * time.Second) // await a fade animation, if present
-	progress = p
+	progress, progressClosed = p, closed
+}
+
+// closeProgress closes the progress dialog. Unlike the user closing it, this doesn't cancel the run.
+func closeProgress() {
+	if progress != nil {
+		progressClosed.Store(true)
+		progress.Close()
+	}
+}
+
+// httpGet is http.Get, but bound to runCtx.
+func httpGet(url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(runCtx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return http.DefaultClient.Do(req)
 }
 
 // https://gist.github.com/clarkmcc/1fdab4472283bb68464d066d6b4169bc?permalink_comment_id=4405804#gistcomment-4405804
@@ -178,24 +173,91 @@ func remove[T any](s []T, i int) []T {
 	return s[:len(s)-1]
 }
 
-func downloadFile(path string, url string) {
+func downloadFile(path string, url string) error {
 	// Make the directory
 	err := os.MkdirAll(filepath.Dir(path), 0755)
-	fatalIfError("Failed to create "+filepath.Dir(path), err)
+	if err != nil {
+		return wrapError(categoryFilesystem, "Failed to create "+filepath.Dir(path), err)
+	}
 
-	// Create the file
-	out, err := os.Create(path)
-	fatalIfError("Failed to create "+path, err)
+	// Create the file. It only gets its real name once it's complete, so nobody picks up half a download
+	out, err := os.Create(path + ".part")
+	if err != nil {
+		return wrapError(categoryFilesystem, "Failed to create "+path, err)
+	}
+	defer os.Remove(path + ".part")
 	defer out.Close()
 
 	// Get the data
-	resp, err := http.Get(url)
-	fatalIfError("Failed to download "+url, err)
+	resp, err := httpGet(url)
+	if err != nil {
+		return wrapError(categoryNetwork, "Failed to download "+url, err)
+	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		return wrapError(categoryNetwork, "Failed to download "+url, errors.New(resp.Status))
+	}
+
 	// Write the body to file
 	_, err = io.Copy(out, resp.Body)
-	fatalIfError("Failed to write "+path, err)
+	if err != nil {
+		return wrapError(categoryNetwork, "Failed to write "+path, err)
+	}
+
+	out.Close()
+	return wrapError(categoryFilesystem, "Failed to write "+path, os.Rename(path+".part", path))
+}
+
+// readRemoteList reads the URLs of the remote plugins from remote.json, without duplicates.
+func readRemoteList() ([]string, error) {
+	f, err := os.OpenFile(filepath.Join(getProfilePath(), "remote.json"), os.O_CREATE|os.O_RDONLY, 0644)
+	if err != nil {
+		return nil, wrapError(categoryFilesystem, "Failed to open remote.json", err)
+	}
+	defer f.Close()
+
+	var data []string = []string{}
+	json.NewDecoder(f).Decode(&data)
+
+	return data, nil
+}
+
+func writeRemoteList(data []string) error {
+	contents, err := json.Marshal(data)
+	if err != nil {
+		return wrapError(categoryUnknown, "Failed to marshal remote.json", err)
+	}
+
+	err = os.WriteFile(filepath.Join(getProfilePath(), "remote.json"), contents, 0644)
+	return wrapError(categoryFilesystem, "Failed to write remote.json", err)
+}
+
+// addRemote adds url to remote.json, if it can be downloaded. Offline, it has to be taken at its word.
+func addRemote(url string) ([]string, error) {
+	data, err := readRemoteList()
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range data {
+		if v == url {
+			return data, nil
+		}
+	}
+
+	if !cli.Offline {
+		b, err := httpGet(url)
+		if err != nil {
+			return nil, wrapError(categoryNetwork, "Invalid plugin URL", err)
+		}
+		b.Body.Close()
+		if b.StatusCode != 200 {
+			return nil, wrapError(categoryNetwork, "Invalid plugin URL", errors.New(b.Status))
+		}
+	}
+
+	data = append(data, url)
+	return data, writeRemoteList(data)
 }
 
 // https://stackoverflow.com/a/66172278
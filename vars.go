@@ -0,0 +1,144 @@
+/*
+	Venjector: Copyright (C) 2023 tizu69
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Reload-time vars are placeholders like $VENJECTOR-SELFPATH in plugin sources, filled in when reloading.
+// The built in ones are in loadReloadVars, your own come from "vars" in config.json and the profile's profile.json.
+var (
+	reloadVarPattern = regexp.MustCompile(`\$VENJECTOR-([A-Za-z0-9_]+)`)
+	varNamePattern   = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+)
+
+// Values are escaped so they can go in a string literal, as that's where paths usually end up
+var (
+	jsEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `'`, `\'`, "`", "\\`", "${", "\\${",
+		"\n", `\n`, "\r", `\r`, "\u2028", `\u2028`, "\u2029", `\u2029`)
+
+	reloadVarEscapes = map[string]func(string) string{
+		".ts": jsEscaper.Replace, ".tsx": jsEscaper.Replace, ".mts": jsEscaper.Replace, ".cts": jsEscaper.Replace,
+		".js": jsEscaper.Replace, ".jsx": jsEscaper.Replace, ".mjs": jsEscaper.Replace, ".cjs": jsEscaper.Replace,
+		".json": jsonEscape,
+		".css":  rawValue, ".html": rawValue, ".md": rawValue, ".txt": rawValue,
+	}
+)
+
+func jsonEscape(s string) string {
+	data, _ := json.Marshal(s)
+	return string(data[1 : len(data)-1])
+}
+
+func rawValue(s string) string {
+	return s
+}
+
+// varsFile is the part of config.json and profile.json with your own vars.
+type varsFile struct {
+	Vars map[string]string `json:"vars"`
+}
+
+// loadReloadVars returns every var for a reload of the checkout in dir.
+func loadReloadVars(dir string) (map[string]string, error) {
+	selfPath, err := os.Executable()
+	if err != nil {
+		return nil, wrapError(categoryFilesystem, "Failed to get self path", err)
+	}
+
+	vars := map[string]string{
+		"SELFPATH":   selfPath,
+		"DATADIR":    getDataPath(),
+		"CONFIGDIR":  getConfigPath(),
+		"CACHEDIR":   getCachePath(),
+		"PROFILE":    currentProfile(),
+		"COMMIT":     checkoutCommit(dir),
+		"BUILDTIME":  time.Now().UTC().Format(time.RFC3339),
+		"DAEMONINFO": daemonInfoPath(),
+	}
+	builtIn := map[string]bool{}
+	for name := range vars {
+		builtIn[name] = true
+	}
+
+	// The profile's go over config.json's
+	for _, path := range []string{filepath.Join(getConfigPath(), "config.json"), filepath.Join(getProfilePath(), "profile.json")} {
+		data, err := os.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, wrapError(categoryFilesystem, "Failed to read "+path, err)
+		}
+
+		file := varsFile{}
+		if err := json.Unmarshal(data, &file); err != nil {
+			return nil, wrapError(categoryPrerequisite, "Failed to read vars from "+path, err)
+		}
+		for name, value := range file.Vars {
+			if !varNamePattern.MatchString(name) {
+				addWarning(fmt.Sprintf("Ignoring var %q from %s, names can only have letters, numbers and '_'", name, path))
+			} else if builtIn[name] {
+				addWarning(fmt.Sprintf("Ignoring var %s from %s, it's built in", name, path))
+			} else {
+				vars[name] = value
+			}
+		}
+	}
+	return vars, nil
+}
+
+// insertReloadVars fills vars into the file at path, if it's a text file we know. Unknown vars are left alone,
+// with a warning.
+func insertReloadVars(path string, vars map[string]string) error {
+	escape, ok := reloadVarEscapes[strings.ToLower(filepath.Ext(path))]
+	if !ok {
+		return nil
+	}
+
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	unknown := map[string]bool{}
+	replaced := reloadVarPattern.ReplaceAllFunc(contents, func(match []byte) []byte {
+		name := string(match[len("$VENJECTOR-"):])
+		value, ok := vars[name]
+		if !ok {
+			unknown[name] = true
+			return match
+		}
+		return []byte(escape(value))
+	})
+	for name := range unknown {
+		addWarning(fmt.Sprintf("Unknown reload-time var $VENJECTOR-%s in %s", name, path))
+	}
+
+	if string(replaced) == string(contents) {
+		return nil
+	}
+	return os.WriteFile(path, replaced, 0644)
+}
@@ -0,0 +1,300 @@
+/*
+	Venjector: Copyright (C) 2023 tizu69
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/charmbracelet/log"
+	"github.com/ncruces/zenity"
+	cp "github.com/otiai10/copy"
+)
+
+// Where Vesktop keeps settings.json: its own name since it was renamed, VencordDesktop/VencordDesktop before that.
+// The Flatpak has its own config directory, and can't read ours.
+func vesktopCandidates() []client {
+	home, _ := os.UserHomeDir()
+	candidates := []client{}
+
+	switch runtime.GOOS {
+	case "linux":
+		config := os.Getenv("XDG_CONFIG_HOME")
+		if config == "" {
+			config = filepath.Join(home, ".config")
+		}
+		flatpak := filepath.Join(home, ".var/app", vesktopFlatpak, "config")
+
+		candidates = append(candidates,
+			client{Name: "Vesktop", Path: filepath.Join(config, "vesktop")},
+			client{Name: "Vesktop (legacy)", Path: filepath.Join(config, "VencordDesktop/VencordDesktop")},
+			client{Name: "Vesktop (Flatpak)", Path: filepath.Join(flatpak, "vesktop"), Sandboxed: true},
+			client{Name: "Vesktop (Flatpak, legacy)", Path: filepath.Join(flatpak, "VencordDesktop/VencordDesktop"), Sandboxed: true})
+	case "darwin":
+		support := filepath.Join(home, "Library/Application Support")
+		candidates = append(candidates,
+			client{Name: "Vesktop", Path: filepath.Join(support, "vesktop")},
+			client{Name: "Vesktop (legacy)", Path: filepath.Join(support, "VencordDesktop/VencordDesktop")})
+	case "windows":
+		candidates = append(candidates,
+			client{Name: "Vesktop", Path: filepath.Join(os.Getenv("APPDATA"), "vesktop")},
+			client{Name: "Vesktop (legacy)", Path: filepath.Join(os.Getenv("APPDATA"), "VencordDesktop\\VencordDesktop")},
+			client{Name: "Vesktop (legacy)", Path: filepath.Join(os.Getenv("LOCALAPPDATA"), "VencordDesktop\\VencordDesktop")})
+	}
+
+	for i := range candidates {
+		candidates[i].Kind = kindVesktop
+	}
+	return candidates
+}
+
+const vesktopFlatpak = "dev.vencord.Vesktop"
+
+// findVesktops returns every Vesktop that has been started at least once.
+func findVesktops() []client {
+	found := []client{}
+	for _, c := range vesktopCandidates() {
+		if _, err := os.Stat(filepath.Join(c.Path, "settings.json")); err == nil {
+			found = append(found, c)
+		}
+	}
+	return found
+}
+
+// vesktopAt makes a Vesktop client out of a config directory given by hand.
+func vesktopAt(dir string) client {
+	for _, c := range vesktopCandidates() {
+		if samePath(c.Path, dir) {
+			return c
+		}
+	}
+	return client{Name: "Vesktop", Kind: kindVesktop, Path: dir,
+		Sandboxed: strings.Contains(filepath.ToSlash(dir), "/.var/app/"+vesktopFlatpak+"/")}
+}
+
+var pickedVesktop *client
+
+// pickVesktop returns the Vesktop to work with, asking which one if there's more than one. It remembers the
+// answer for the rest of the run.
+func pickVesktop() (client, error) {
+	if pickedVesktop != nil {
+		return *pickedVesktop, nil
+	}
+
+	found := findVesktops()
+	switch len(found) {
+	case 0:
+		return client{}, wrapError(categoryPrerequisite, "Vesktop not found, start it once first", os.ErrNotExist)
+	case 1:
+		pickedVesktop = &found[0]
+		return found[0], nil
+	}
+
+	items := []string{}
+	for _, c := range found {
+		items = append(items, c.Name+" - "+c.Path)
+	}
+	sel, err := zenity.List("There's more than one Vesktop, which one do you use?", items,
+		zenity.Title("Venjector"), zenity.DisallowEmpty(), zenity.Width(768))
+	if err != nil {
+		return client{}, wrapError(categoryCanceled, "No Vesktop picked", err)
+	}
+	for i, item := range items {
+		if item == sel {
+			pickedVesktop = &found[i]
+		}
+	}
+	return *pickedVesktop, nil
+}
+
+// vesktopBuildDir is the build c loads. A sandboxed Vesktop can only read its own directory, so it gets a copy.
+func vesktopBuildDir(c client) string {
+	return profileVesktopBuildDir(c, currentProfile())
+}
+
+func profileVesktopBuildDir(c client, name string) string {
+	if c.Sandboxed {
+		return filepath.Join(profileDir(filepath.Join(flatpakAppDir(c.Path), "data", "venjector"), name), "dist")
+	}
+	return filepath.Join(profileDir(getDataPath(), name), repoDir, "dist")
+}
+
+// flatpakAppDir returns the Flatpak's ~/.var/app/dev.vencord.Vesktop for a config directory in it, however deep
+// that is: the legacy one is a level deeper than the current one.
+func flatpakAppDir(path string) string {
+	marker := "/.var/app/" + vesktopFlatpak
+	slashed := filepath.ToSlash(path) + "/"
+	if i := strings.Index(slashed, marker+"/"); i >= 0 {
+		return filepath.FromSlash(slashed[:i+len(marker)])
+	}
+
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".var/app", vesktopFlatpak)
+}
+
+// copyBuildForSandbox puts a fresh copy of the build where the sandboxed Vesktop c can read it.
+func copyBuildForSandbox(c client) error {
+	to := vesktopBuildDir(c)
+	log.Info("Copying build for the Flatpak sandbox", "to", to)
+
+	os.RemoveAll(to + ".new")
+	if err := cp.Copy(filepath.Join(getBuildPath(), repoDir, "dist"), to+".new"); err != nil {
+		return wrapError(categoryFilesystem, "Failed to copy build for Vesktop", err)
+	}
+	os.RemoveAll(to)
+	return wrapError(categoryFilesystem, "Failed to copy build for Vesktop", os.Rename(to+".new", to))
+}
+
+// refreshVesktopCopies updates the copies of the build sandboxed Vesktops load, after a rebuild.
+func refreshVesktopCopies() error {
+	for _, c := range findVesktops() {
+		if !c.Sandboxed {
+			continue
+		}
+		if !samePath(getStatus(c).Target, vesktopBuildDir(c)) {
+			continue
+		}
+		if err := copyBuildForSandbox(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func injeccVesktop() error {
+	c, err := pickVesktop()
+	if err != nil {
+		return err
+	}
+	return injectVesktop(c)
+}
+
+// injectVesktop points Vesktop c at our build.
+func injectVesktop(c client) error {
+	log.Info("Injecting Vencord with Venjector", "vesktop", c.Path)
+
+	if c.Sandboxed {
+		if err := copyBuildForSandbox(c); err != nil {
+			return err
+		}
+	}
+
+	err := editVesktopSettings(c.Path, func(data []byte) ([]byte, error) {
+		return setJSONKey(data, "vencordDir", vesktopBuildDir(c))
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Info("Successfully injected Vencord with Venjector")
+	return nil
+}
+
+func uninjeccVesktop() error {
+	c, err := pickVesktop()
+	if err != nil {
+		return err
+	}
+	return uninjectVesktop(c)
+}
+
+// uninjectVesktop makes Vesktop c use its own Vencord again, same as the Reset button in its settings.
+func uninjectVesktop(c client) error {
+	log.Info("Uninjecting Vesktop", "vesktop", c.Path)
+	err := editVesktopSettings(c.Path, func(data []byte) ([]byte, error) {
+		return deleteJSONKey(data, "vencordDir")
+	})
+	if err != nil {
+		return err
+	}
+
+	if c.Sandboxed {
+		os.RemoveAll(filepath.Dir(vesktopBuildDir(c)))
+	}
+
+	log.Info("Successfully uninjected Vesktop")
+	return nil
+}
+
+// editVesktopSettings changes settings.json in dir with edit. Vesktop writes its settings back when it exits, so
+// it mustn't be running, and the old file is kept as settings.json.venjector.bak.
+func editVesktopSettings(dir string, edit func([]byte) ([]byte, error)) error {
+	if vesktopRunning() {
+		return wrapError(categoryPrerequisite, "Vesktop is running, close it first", errors.New("vesktop is running"))
+	}
+
+	path := filepath.Join(dir, "settings.json")
+	info, err := os.Stat(path)
+	if err != nil {
+		return wrapError(categoryFilesystem, "Failed to read settings.json", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return wrapError(categoryFilesystem, "Failed to read settings.json", err)
+	}
+
+	edited, err := edit(data)
+	if err != nil {
+		return wrapError(categoryFilesystem, "Failed to edit settings.json", err)
+	}
+	if bytes.Equal(edited, data) {
+		log.Info("settings.json is already up to date")
+		return nil
+	}
+
+	backup := path + ".venjector.bak"
+	log.Info("Backing up settings.json", "to", backup)
+	if err := os.WriteFile(backup, data, info.Mode().Perm()); err != nil {
+		return wrapError(categoryFilesystem, "Failed to back up settings.json", err)
+	}
+
+	// Write next to it and swap, so a crash can't leave Vesktop with half a file
+	tmp := path + ".venjector.tmp"
+	if err := os.WriteFile(tmp, edited, info.Mode().Perm()); err != nil {
+		return wrapError(categoryFilesystem, "Failed to write settings.json", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return wrapError(categoryFilesystem, "Failed to write settings.json", err)
+	}
+	return nil
+}
+
+// vesktopRunning tells if any Vesktop is running. When we can't tell, it isn't.
+func vesktopRunning() bool {
+	switch runtime.GOOS {
+	case "linux":
+		procs, _ := filepath.Glob("/proc/[0-9]*/comm")
+		for _, comm := range procs {
+			name, err := os.ReadFile(comm)
+			if err == nil && strings.EqualFold(strings.TrimSpace(string(name)), "vesktop") {
+				return true
+			}
+		}
+		return false
+	case "windows":
+		out, err := newCommand("tasklist", "/FI", "IMAGENAME eq Vesktop.exe", "/NH").Output()
+		return err == nil && bytes.Contains(bytes.ToLower(out), []byte("vesktop.exe"))
+	default:
+		return newCommand("pgrep", "-xi", "vesktop").Run() == nil
+	}
+}
@@ -0,0 +1,35 @@
+/*
+	Venjector: Copyright (C) 2023 tizu69
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestFlatpakVesktopBuildDir(t *testing.T) {
+	app := filepath.Join(t.TempDir(), ".var", "app", vesktopFlatpak)
+	want := filepath.Join(app, "data", "venjector", "dist")
+
+	for _, config := range []string{"vesktop", "VencordDesktop/VencordDesktop"} {
+		c := client{Kind: kindVesktop, Path: filepath.Join(app, "config", config), Sandboxed: true}
+		if got := profileVesktopBuildDir(c, defaultProfile); got != want {
+			t.Errorf("build for %s goes to %s, want %s", config, got, want)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+/*
+	Venjector: Copyright (C) 2023 tizu69
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU General Public License as published by
+    the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU General Public License for more details.
+
+    You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/log"
+	cp "github.com/otiai10/copy"
+)
+
+// Watch mode keeps the last build in sync with overrides: changed files are copied into cord and it's rebuilt
+// with just 'pnpm build', no cloning or installing. It polls, which is plenty for a tree of plugin sources.
+const watchInterval = time.Second
+
+// The build stamp is written into dist after every watch build, the core plugin looks for it to tell you.
+const buildStampFile = "venjector-build.json"
+
+type buildStamp struct {
+	Time    int64    `json:"time"` // Unix milliseconds
+	Changed []string `json:"changed"`
+}
+
+// fileState is what we compare to notice changes, like make does.
+type fileState struct {
+	size    int64
+	modTime time.Time
+}
+
+// snapshotTree records every file under root, by path relative to it.
+func snapshotTree(root string) (map[string]fileState, error) {
+	files := map[string]fileState{}
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil // deleted while we were looking
+			}
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		files[rel] = fileState{info.Size(), info.ModTime()}
+		return nil
+	})
+	return files, err
+}
+
+// diffSnapshots lists the files that are new or changed in current, and the ones that are gone from it.
+func diffSnapshots(last, current map[string]fileState) (changed, removed []string) {
+	for path, state := range current {
+		if old, ok := last[path]; !ok || old != state {
+			changed = append(changed, path)
+		}
+	}
+	for path := range last {
+		if _, ok := current[path]; !ok {
+			removed = append(removed, path)
+		}
+	}
+	return changed, removed
+}
+
+// watchCommand is 'venjector watch'. It runs until Ctrl-C.
+func watchCommand() error {
+	repoLocation := filepath.Join(getBuildPath(), repoDir)
+	overridesLocation := filepath.Join(getProfilePath(), "overrides")
+
+	if _, err := os.Stat(repoLocation); err != nil {
+		return wrapError(categoryPrerequisite, "There's no build to keep up to date, reload plugins once first", err)
+	}
+	if err := os.MkdirAll(overridesLocation, 0755); err != nil {
+		return wrapError(categoryFilesystem, "Failed to create overrides directory", err)
+	}
+
+	last, err := snapshotTree(overridesLocation)
+	if err != nil {
+		return wrapError(categoryFilesystem, "Failed to look at overrides", err)
+	}
+
+	log.Info("Watching for changes, press Ctrl-C to stop", "overrides", overridesLocation, "build", repoLocation)
+	pending := map[string]bool{} // changed paths, true if they're gone
+	for {
+		select {
+		case <-runCtx.Done():
+			log.Info("Stopped watching")
+			return nil
+		case <-time.After(watchInterval):
+		}
+
+		current, err := snapshotTree(overridesLocation)
+		if err != nil {
+			log.Warn("Failed to look at overrides", "err", err)
+			continue
+		}
+
+		changed, removed := diffSnapshots(last, current)
+		last = current
+		for _, path := range changed {
+			pending[path] = false
+		}
+		for _, path := range removed {
+			pending[path] = true
+		}
+
+		// Editors and git write files in several steps, so wait until nothing changed for a moment
+		if len(changed) != 0 || len(removed) != 0 || len(pending) == 0 {
+			continue
+		}
+
+		if err := watchBuild(repoLocation, overridesLocation, pending); err != nil {
+			log.Error("Watch build failed, fix it and save again", "category", categoryOf(err), "err", err)
+		} else {
+			log.Info("New build ready, restart your client to use it", "changed", len(pending))
+		}
+		pending = map[string]bool{}
+	}
+}
+
+// watchBuild brings the changed files into the build in repoLocation and builds it.
+func watchBuild(repoLocation, overridesLocation string, changes map[string]bool) error {
+	vars, err := loadReloadVars(repoLocation)
+	if err != nil {
+		return err
+	}
+
+	paths := []string{}
+	for path, gone := range changes {
+		paths = append(paths, path)
+		target := filepath.Join(repoLocation, path)
+
+		if !gone {
+			log.Info("Copying", "file", path)
+			if err := cp.Copy(filepath.Join(overridesLocation, path), target); err != nil {
+				return wrapError(categoryFilesystem, "Failed to copy "+path, err)
+			}
+			if strings.HasPrefix(filepath.ToSlash(path), "src/userplugins/") {
+				if err := insertReloadVars(target, vars); err != nil {
+					return wrapError(categoryFilesystem, "Failed to insert reload-time vars into "+path, err)
+				}
+			}
+			continue
+		}
+
+		// An override of one of Vencord's own files goes back to Vencord's version, anything else just goes
+		log.Info("Removing", "file", path)
+		command := newCommand("git", "checkout", "--", filepath.ToSlash(path))
+		command.Dir = repoLocation
+		if command.Run() == nil {
+			continue
+		}
+		if err := os.Remove(target); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return wrapError(categoryFilesystem, "Failed to remove "+path, err)
+		}
+	}
+	sort.Strings(paths)
+
+	// Those may have been files Venjector writes or changes, which a full build would redo after the overrides
+	err = writeCore(repoLocation, vars, func(path string) bool {
+		_, ok := changes[filepath.FromSlash(path)]
+		return ok
+	})
+	if err != nil {
+		return err
+	}
+
+	err = runPnpm(runTask{
+		Name: "pnpm-build",
+		Dir:  repoLocation,
+		Args: []string{"build"},
+	})
+	if err != nil {
+		return wrapError(categoryBuild, "Failed to run PNPM", err)
+	}
+
+	stamp, err := json.Marshal(buildStamp{Time: time.Now().UnixMilli(), Changed: paths})
+	if err != nil {
+		return wrapError(categoryUnknown, "Failed to marshal build stamp", err)
+	}
+	if err := os.WriteFile(filepath.Join(repoLocation, "dist", buildStampFile), stamp, 0644); err != nil {
+		return wrapError(categoryFilesystem, "Failed to write build stamp", err)
+	}
+
+	return refreshVesktopCopies()
+}